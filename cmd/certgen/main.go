@@ -0,0 +1,30 @@
+// Command certgen writes a self-signed certificate and key for local development
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/internal/certs"
+)
+
+func main() {
+	dir := flag.String("out", ".", "directory to write cert.pem and key.pem to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated DNS names and IPs of the certificate")
+	validFor := flag.Duration("valid-for", 365*24*time.Hour, "certificate lifetime")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	certFile, keyFile, err := certs.WriteSelfSigned(*dir, strings.Split(*hosts, ","), *validFor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Infof("certificate written to %s, key written to %s", certFile, keyFile)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// serverConn is a connection to the quotes server speaking one of the protocol modes
+type serverConn struct {
+	net.Conn
+	codec protocol.Codec
+
+	// greeting is the challenge the server sends right after the connection is established
+	greeting protocol.Message
+}
+
+// dial connects to the server, over TLS if tlsConfig is set,
+// reads the greeting challenge and negotiates the protocol mode
+func dial(url, mode string, tlsConfig *tls.Config) (*serverConn, error) {
+	// a unix:///path url connects to a local Unix socket of the server
+	network, address := "tcp", url
+	if path, ok := strings.CutPrefix(url, "unix://"); ok {
+		network, address = "unix", path
+	}
+
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.Dial(network, address, tlsConfig)
+	} else {
+		conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to the server")
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to read challenge from server")
+	}
+
+	if msg, ok := protocol.ParseTextError(line); ok {
+		conn.Close()
+		return nil, fmt.Errorf("server replied with %s: %s", msg.Code, msg.Error)
+	}
+
+	c := &serverConn{
+		Conn:     conn,
+		codec:    newTextCodec(reader, conn),
+		greeting: protocol.Message{Type: protocol.TypeChallenge, Challenge: strings.TrimSpace(line)},
+	}
+
+	if mode == protocol.ModeText {
+		return c, nil
+	}
+
+	fmt.Fprintf(conn, "%s %s\n", protocol.NegotiateCommand, mode)
+
+	switch mode {
+	case protocol.ModeJSON:
+		c.codec = protocol.NewJSONCodec(reader, conn)
+	case protocol.ModeBinary:
+		c.codec = protocol.NewBinaryCodec(reader, conn)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unknown protocol mode %q", mode)
+	}
+
+	c.greeting, err = c.codec.Read()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to read challenge from server")
+	}
+
+	if c.greeting.Type != protocol.TypeChallenge {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected %s message instead of challenge", c.greeting.Type)
+	}
+
+	return c, nil
+}
+
+// textCodec is the client side of the line based text protocol
+type textCodec struct {
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+func newTextCodec(reader *bufio.Reader, writer io.Writer) *textCodec {
+	return &textCodec{reader: reader, writer: writer}
+}
+
+func (c *textCodec) Read() (protocol.Message, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return protocol.Message{}, err
+	}
+	line = strings.TrimSpace(line)
+
+	if challenge, ok := strings.CutPrefix(line, protocol.ChallengePrefix+" "); ok {
+		return protocol.Message{Type: protocol.TypeChallenge, Challenge: challenge}, nil
+	}
+
+	if msg, ok := protocol.ParseTextError(line); ok {
+		return msg, nil
+	}
+
+	return protocol.Message{Type: protocol.TypeQuote, Quote: &protocol.Quote{Text: line}}, nil
+}
+
+func (c *textCodec) Write(msg protocol.Message) error {
+	var err error
+
+	switch msg.Type {
+	case protocol.TypeSolution:
+		_, err = fmt.Fprintf(c.writer, "%d\n", msg.Nonce)
+	case protocol.TypeCommand:
+		_, err = fmt.Fprintf(c.writer, "%s %s\n", msg.Command, msg.Args)
+	default:
+		err = fmt.Errorf("message type %q is not supported in text mode", msg.Type)
+	}
+
+	return err
+}
@@ -1,19 +1,19 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"fmt"
-	"net"
+	"crypto/tls"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/internal/certs"
 	"github.com/zhashkevych/quotes-server/pkg/hashcash"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
 )
 
 const (
@@ -33,12 +33,43 @@ func init() {
 	SetLogLevel()
 }
 
+// client requests quotes from the server in the configured protocol mode
+type client struct {
+	url        string
+	mode       string
+	tlsConfig  *tls.Config
+	powManager *hashcash.Hashcash
+}
+
 func main() {
 	url := os.Getenv("SERVER_URL")
 	if url == "" {
 		url = defaultServerURL
 	}
-	powManager := hashcash.New()
+
+	mode := os.Getenv("PROTOCOL")
+	if mode == "" {
+		mode = protocol.ModeText
+	}
+
+	c := &client{
+		url:        url,
+		mode:       mode,
+		powManager: hashcash.New(),
+	}
+
+	if useTLS, _ := strconv.ParseBool(os.Getenv("TLS")); useTLS {
+		tlsConfig, err := certs.NewClientConfig(certs.Config{
+			CertFile:   os.Getenv("TLS_CERT_FILE"),
+			KeyFile:    os.Getenv("TLS_KEY_FILE"),
+			CAFile:     os.Getenv("TLS_CA_FILE"),
+			ServerName: os.Getenv("TLS_SERVER_NAME"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.tlsConfig = tlsConfig
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -51,20 +82,17 @@ func main() {
 
 	var wg sync.WaitGroup
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					sendRequest(ctx, url, powManager)
-				}()
-			}
-		}
-	}()
+	// datagrams have no sessions
+	keepAlive, _ := strconv.ParseBool(os.Getenv("KEEP_ALIVE"))
+	if keepAlive && mode != modeUDP {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.runSession(ctx, ticker)
+		}()
+	} else {
+		go c.sendRequests(ctx, ticker, &wg)
+	}
 
 	<-shutdownChan
 	log.Info("Shutdown signal received; stopping new requests...")
@@ -76,7 +104,27 @@ func main() {
 	logMetrics()
 }
 
-func sendRequest(ctx context.Context, url string, powManager *hashcash.Hashcash) {
+// sendRequests opens a new connection for every quote
+func (c *client) sendRequests(ctx context.Context, ticker *time.Ticker, wg *sync.WaitGroup) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if c.mode == modeUDP {
+					c.sendRequestUDP(ctx)
+				} else {
+					c.sendRequest(ctx)
+				}
+			}()
+		}
+	}
+}
+
+func (c *client) sendRequest(ctx context.Context) {
 	select {
 	case <-ctx.Done():
 		return
@@ -85,10 +133,10 @@ func sendRequest(ctx context.Context, url string, powManager *hashcash.Hashcash)
 
 	startTime := time.Now()
 
-	conn, err := net.Dial("tcp", url)
+	conn, err := dial(c.url, c.mode, c.tlsConfig)
 	if err != nil {
 		incrementErrorCount()
-		log.Error("Failed to connect to the server:", err)
+		log.Error(err)
 		return
 	}
 	defer conn.Close()
@@ -98,39 +146,151 @@ func sendRequest(ctx context.Context, url string, powManager *hashcash.Hashcash)
 		conn.Close()
 	}()
 
-	log.Debugf("Connected to server at %s", url)
+	log.Debugf("Connected to server at %s", c.url)
 	incrementRequestsCount()
 
-	reader := bufio.NewReader(conn)
-	challenge, err := reader.ReadString('\n')
+	log.Debugf("Challenge received: %s", conn.greeting.Challenge)
+
+	if !c.solve(conn, conn.greeting.Challenge) {
+		return
+	}
+
+	msg, err := conn.codec.Read()
 	if err != nil {
 		incrementErrorCount()
-		log.Error("Failed to read challenge from server:", err)
+		log.Error("Failed to read quote from server:", err)
 		return
 	}
-	challenge = strings.TrimSpace(challenge)
 
-	log.Debugf("Challenge received: %s", challenge)
+	if c.handleReply(msg) {
+		collectResponseTimeMetric(startTime, time.Now())
+	}
+}
 
-	nonce, err := powManager.SolveChallenge(challenge)
+// runSession requests quotes over a single keep-alive connection, reconnecting when it breaks
+func (c *client) runSession(ctx context.Context, ticker *time.Ticker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		conn, err := dial(c.url, c.mode, c.tlsConfig)
+		if err != nil {
+			incrementErrorCount()
+			log.Error(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		log.Debugf("Connected to server at %s", c.url)
+		c.serveSession(ctx, conn, ticker)
+		conn.Close()
+	}
+}
+
+func (c *client) serveSession(ctx context.Context, conn *serverConn, ticker *time.Ticker) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.codec.Write(protocol.Message{Type: protocol.TypeCommand, Command: protocol.CommandQuit})
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// the greeting challenge is solved lazily, once the server asks for it
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		startTime := time.Now()
+		incrementRequestsCount()
+
+		err := conn.codec.Write(protocol.Message{Type: protocol.TypeCommand, Command: protocol.CommandQuote})
+		if err != nil {
+			incrementErrorCount()
+			log.Error("Failed to send command to server:", err)
+			return
+		}
+
+		msg, err := conn.codec.Read()
+		if err != nil {
+			incrementErrorCount()
+			log.Error("Failed to read response from server:", err)
+			return
+		}
+
+		if msg.Type == protocol.TypeChallenge {
+			log.Debugf("Challenge received: %s", msg.Challenge)
+
+			if !c.solve(conn, msg.Challenge) {
+				return
+			}
+
+			msg, err = conn.codec.Read()
+			if err != nil {
+				incrementErrorCount()
+				log.Error("Failed to read quote from server:", err)
+				return
+			}
+		}
+
+		if !c.handleReply(msg) {
+			return
+		}
+
+		collectResponseTimeMetric(startTime, time.Now())
+	}
+}
+
+// solve finds the nonce for the challenge and sends it to the server
+func (c *client) solve(conn *serverConn, challenge string) bool {
+	nonce, err := c.powManager.SolveChallenge(challenge)
 	if err != nil {
 		incrementErrorCount()
 		log.Error("Failed to solve challenge from server:", err)
-		return
+		return false
 	}
-	fmt.Fprintf(conn, "%d\n", nonce)
 
-	quote, err := reader.ReadString('\n')
-	if err != nil {
+	if err := conn.codec.Write(protocol.Message{Type: protocol.TypeSolution, Nonce: nonce}); err != nil {
 		incrementErrorCount()
-		log.Error("Failed to read quote from server:", err)
-		return
+		log.Error("Failed to send solution to server:", err)
+		return false
 	}
-	log.Infof("Quote received: %s", quote)
 
-	endTime := time.Now()
+	return true
+}
 
-	collectResponseTimeMetric(startTime, endTime)
+// handleReply logs the quote or the error the server has replied with
+func (c *client) handleReply(msg protocol.Message) bool {
+	switch msg.Type {
+	case protocol.TypeQuote:
+		log.Infof("Quote received: %s", msg.Quote.Text)
+		if msg.Quote.Author != "" {
+			log.Debugf("Quote #%d by %s, tags: %v", msg.Quote.ID, msg.Quote.Author, msg.Quote.Tags)
+		}
+		return true
+	case protocol.TypeError:
+		incrementErrorCount()
+		log.Errorf("Server replied with %s: %s", msg.Code, msg.Error)
+		return false
+	default:
+		incrementErrorCount()
+		log.Errorf("Unexpected %s message from server", msg.Type)
+		return false
+	}
 }
 
 func incrementErrorCount() {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+const (
+	// modeUDP requests quotes in datagrams instead of TCP connections
+	modeUDP = "udp"
+
+	// udpDatagramSize is the size requests are padded to,
+	// the server never answers with more bytes than it received
+	udpDatagramSize = 1024
+	udpTimeout      = 5 * time.Second
+)
+
+// sendRequestUDP gets a challenge in a datagram and exchanges the solution for a quote
+func (c *client) sendRequestUDP(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	startTime := time.Now()
+	incrementRequestsCount()
+
+	conn, err := net.Dial("udp", c.url)
+	if err != nil {
+		incrementErrorCount()
+		log.Error(errors.Wrap(err, "failed to connect to the server"))
+		return
+	}
+	defer conn.Close()
+
+	reply, err := exchangeDatagram(conn, protocol.CommandQuote)
+	if err != nil {
+		incrementErrorCount()
+		log.Error("Failed to get challenge from server:", err)
+		return
+	}
+
+	challenge, ok := strings.CutPrefix(reply, protocol.ChallengePrefix+" ")
+	if !ok {
+		incrementErrorCount()
+		log.Errorf("Unexpected reply instead of challenge: %s", reply)
+		return
+	}
+
+	log.Debugf("Challenge received: %s", challenge)
+
+	nonce, err := c.powManager.SolveChallenge(challenge)
+	if err != nil {
+		incrementErrorCount()
+		log.Error("Failed to solve challenge from server:", err)
+		return
+	}
+
+	reply, err = exchangeDatagram(conn, fmt.Sprintf("%s %s %d", protocol.CommandQuote, challenge, nonce))
+	if err != nil {
+		incrementErrorCount()
+		log.Error("Failed to read quote from server:", err)
+		return
+	}
+
+	if msg, ok := protocol.ParseTextError(reply); ok {
+		incrementErrorCount()
+		log.Errorf("Server replied with %s: %s", msg.Code, msg.Error)
+		return
+	}
+
+	log.Infof("Quote received: %s", reply)
+	collectResponseTimeMetric(startTime, time.Now())
+}
+
+// exchangeDatagram sends the request padded to udpDatagramSize and returns the first line of the reply
+func exchangeDatagram(conn net.Conn, request string) (string, error) {
+	datagram := make([]byte, udpDatagramSize)
+	copy(datagram, request+"\n")
+	for i := len(request) + 1; i < len(datagram); i++ {
+		datagram[i] = ' '
+	}
+
+	if _, err := conn.Write(datagram); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(udpTimeout))
+
+	buf := make([]byte, udpDatagramSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	line, _, _ := strings.Cut(string(buf[:n]), "\n")
+	return line, nil
+}
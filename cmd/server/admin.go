@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zhashkevych/quotes-server/internal/config"
+	"github.com/zhashkevych/quotes-server/internal/control"
+	"github.com/zhashkevych/quotes-server/internal/metrics"
+)
+
+const (
+	// adminReadHeaderTimeout bounds reading the headers of an admin request
+	adminReadHeaderTimeout = 5 * time.Second
+	// healthcheckTimeout bounds a probe of the healthcheck command
+	healthcheckTimeout = 3 * time.Second
+)
+
+// newAdminServer serves the operational endpoints on the admin listener.
+// /healthz answers as long as the process serves requests, /readyz while readiness returns nil.
+func newAdminServer(registry *metrics.Registry, readiness func() error) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := readiness(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+
+	return &http.Server{Handler: mux, ReadHeaderTimeout: adminReadHeaderTimeout}
+}
+
+// newControlServer serves the control plane on its own listener
+func newControlServer(config control.Config) *http.Server {
+	return &http.Server{Handler: control.NewHandler(config), ReadHeaderTimeout: adminReadHeaderTimeout}
+}
+
+// healthcheck is the healthcheck admin command. It loads the config the way the server does
+// and probes /healthz and /readyz on the local admin listener, e.g. for a container health check.
+func healthcheck(args []string) error {
+	loaded, err := config.Load(args, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+
+	port := loaded.Config.Listen.AdminPort
+	if port == 0 {
+		return fmt.Errorf("admin_port is not set, the server has no health endpoints")
+	}
+
+	client := &http.Client{Timeout: healthcheckTimeout}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port) + path)
+		if err != nil {
+			return err
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s %s", path, resp.Status, bytes.TrimSpace(body))
+		}
+	}
+
+	fmt.Println("Server is ready")
+
+	return nil
+}
@@ -2,89 +2,416 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/zhashkevych/quotes-server/internal/certs"
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/config"
+	"github.com/zhashkevych/quotes-server/internal/control"
+	"github.com/zhashkevych/quotes-server/internal/handoff"
+	"github.com/zhashkevych/quotes-server/internal/metrics"
 	quotes "github.com/zhashkevych/quotes-server/internal/quotes/yml"
+	"github.com/zhashkevych/quotes-server/internal/reputation"
 	"github.com/zhashkevych/quotes-server/internal/server"
-	"github.com/zhashkevych/quotes-server/pkg/hashcash"
 
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
 const (
-	defaultListenPort    = 9000
-	defaultPowDifficulty = 4
-	defaultYMLFilePath   = "./quotes.yml"
+	defaultChallengeTTL = time.Minute
+	restartTimeout      = 30 * time.Second
 )
 
-func init() {
-	log.SetFormatter(&log.JSONFormatter{})
-	log.SetOutput(os.Stdout)
-	SetLogLevel()
-}
-
 func main() {
-	listenPort, _ := strconv.Atoi(os.Getenv("LISTEN_PORT"))
-	if listenPort == 0 {
-		listenPort = defaultListenPort
+	// the admin command validates the config and makes the running server reload it
+	if len(os.Args) > 1 && os.Args[1] == "reload" {
+		if err := sendReload(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	powDifficulty, _ := strconv.Atoi(os.Getenv("POW_DIFFICULTY"))
-	if powDifficulty == 0 {
-		powDifficulty = defaultPowDifficulty
+	// the healthcheck command probes the admin listener of the local server
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		if err := healthcheck(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	ymlFilePath := os.Getenv("QUOTES_FILEPATH")
-	if ymlFilePath == "" {
-		ymlFilePath = defaultYMLFilePath
+	loaded, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	cfg := loaded.Config
 
-	quotesService, err := quotes.NewYMLService(ymlFilePath)
+	if loaded.PrintConfig {
+		out, err := yaml.Marshal(cfg.Redacted())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	setupLog(cfg.Log)
+
+	quotesService, err := quotes.NewYMLService(cfg.Quotes.File)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	powManager := hashcash.New()
+	tracker := reputation.New(reputation.Config{
+		BanThreshold: cfg.Bans.Threshold,
+		BanDuration:  cfg.Bans.Duration,
+	})
+
+	registry := metrics.NewRegistry()
+
+	accessLog, err := newAccessLog(cfg.AccessLog)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	srv := server.NewTCPServer(listenPort, powDifficulty, quotesService, powManager)
+	opts := []server.Option{
+		server.WithPort(cfg.Listen.Port),
+		server.WithPowDifficulty(cfg.PoW.Difficulty),
+		server.WithQuotes(quotesService),
+		server.WithSettings(cfg.Settings()),
+		server.WithWorkerPool(cfg.PoolPolicy()),
+		server.WithReputation(tracker),
+		server.WithMetricsSink(metrics.NewServerMetrics(registry)),
+	}
+	if accessLog != nil {
+		opts = append(opts, server.WithAccessLog(accessLog, cfg.AccessLog.SampleRate))
+	}
+
+	// sockets passed by a restarting parent process or by systemd socket activation
+	inherited, err := handoff.Inherit()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listenerConfigs, err := cfg.ListenerConfigs()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listenerOpts, err := listenerOptions(listenerConfigs, cfg.Listen.Port, inherited)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, listenerOpts...)
+
+	trusted, err := cfg.ProxyTrusted()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(trusted) > 0 {
+		opts = append(opts, server.WithProxyProtocol(trusted))
+	}
+
+	var tlsLoader *certs.Loader
+	if cfg.TLS.CertFile != "" {
+		var tlsConfig *tls.Config
+		tlsConfig, tlsLoader, err = certs.NewServerConfig(certs.Config{
+			CertFile: cfg.TLS.CertFile,
+			KeyFile:  cfg.TLS.KeyFile,
+			CAFile:   cfg.TLS.ClientCAFile,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts = append(opts, server.WithTLS(tlsConfig))
+	}
+
+	srv, err := server.New(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	metrics.RegisterDifficulty(registry, func() int { return srv.Live().PowDifficulty })
+
+	// the stateless frontends share the signing key
+	signer, err := challenge.NewSigner([]byte(cfg.PoW.ChallengeSecret), defaultChallengeTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var httpSrv *server.HTTPServer
+	var httpL net.Listener
+	if cfg.Listen.HTTPPort != 0 {
+		httpSrv = server.NewHTTPServer(cfg.Listen.HTTPPort, srv, signer)
+		if httpL, err = httpListener(cfg.Listen.HTTPPort, inherited); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var udpSrv *server.UDPServer
+	var udpC net.PacketConn
+	if cfg.Listen.UDPPort != 0 {
+		udpSrv = server.NewUDPServer(cfg.Listen.UDPPort, srv, signer)
+		if udpC, err = udpConn(cfg.Listen.UDPPort, inherited); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var adminSrv *http.Server
+	var adminL net.Listener
+	if cfg.Listen.AdminPort != 0 {
+		adminSrv = newAdminServer(registry, srv.Readiness)
+		if adminL, err = adminListener(cfg.Listen.AdminPort, inherited); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// a failure of the TCP server stops the process like a signal, but it exits with an error
+	ctx, failServe := context.WithCancel(ctx)
+	defer failServe()
+	serveFailed := make(chan error, 1)
+
+	// the control plane reloads the quotes from the file of the current config
+	var current atomic.Pointer[config.Config]
+	current.Store(&cfg)
+
+	var controlSrv *http.Server
+	var controlL net.Listener
+	if cfg.Listen.ControlPort != 0 {
+		controlSrv = newControlServer(control.Config{
+			Token:  cfg.Control.Token,
+			Server: srv,
+			Bans:   tracker,
+			LoadQuotes: func() (server.Quoter, error) {
+				quotesService, err := quotes.NewYMLService(current.Load().Quotes.File)
+				if err != nil {
+					return nil, err
+				}
+				return quotesService, nil
+			},
+			// the process keeps running until it's stopped, e.g. once the load balancer took it out
+			Drain: srv.Drain,
+			Log:   log.StandardLogger(),
+			Audit: newAuditLog(cfg.Log),
+		})
+		if controlL, err = controlListener(cfg.Control.Host, cfg.Listen.ControlPort, inherited); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		if err := srv.ListenAndServe(); err != nil {
 			log.Error("Server stopped with error:", err)
+			serveFailed <- err
+			failServe()
 		}
 	}()
 
-	<-ctx.Done()
+	if httpSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpSrv.Serve(httpL); err != nil {
+				log.Error("HTTP server stopped with error:", err)
+			}
+		}()
+	}
+
+	if udpSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := udpSrv.Serve(udpC); err != nil {
+				log.Error("UDP server stopped with error:", err)
+			}
+		}()
+	}
+
+	if adminSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := adminSrv.Serve(adminL); err != nil && err != http.ErrServerClosed {
+				log.Error("Admin server stopped with error:", err)
+			}
+		}()
+	}
+
+	if controlSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := controlSrv.Serve(controlL); err != nil && err != http.ErrServerClosed {
+				log.Error("Control server stopped with error:", err)
+			}
+		}()
+	}
+
+	// the sockets are served once the TCP server listens, as the frontends' are already open
+	select {
+	case <-srv.Ready():
+		if err := handoff.Ready(); err != nil {
+			log.Error("Failed to report readiness:", err)
+		}
+		if err := writePIDFile(cfg.PIDFile); err != nil {
+			log.Error("Failed to write the PID file:", err)
+		}
+	case <-ctx.Done():
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2, syscall.SIGHUP)
+
+	handedOff := false
+	for !handedOff && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Info("Reloading the configuration...")
+
+				next, err := reload(cfg, srv, tracker, tlsLoader)
+				if err != nil {
+					log.Error("Reload failed, keeping the current configuration: ", err)
+					continue
+				}
+				cfg = next
+				current.Store(&next)
+				continue
+			}
+
+			log.Info("Restarting with a new process...")
+
+			restartCtx, cancel := context.WithTimeout(ctx, restartTimeout)
+			err := restart(restartCtx, srv, httpL, udpC, adminL, controlL)
+			cancel()
+
+			if err != nil {
+				log.Error("Restart failed, keep serving:", err)
+				continue
+			}
+			handedOff = true
+		}
+	}
+
 	log.Info("Shutting down server...")
 
-	srv.Shutdown()
+	if handedOff {
+		// the new process accepts the connections from now on
+		srv.CloseListeners()
+	}
+
+	if udpSrv != nil {
+		udpSrv.Shutdown()
+	}
+
+	// in-flight exchanges get until the deadline to finish, a second signal kills them right away
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	shutdownCtx, cancel := context.WithTimeout(signalCtx, cfg.Limits.ShutdownTimeout)
+	defer cancel()
+
+	if httpSrv != nil {
+		httpSrv.Shutdown(shutdownCtx)
+	}
+
+	report, err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Warnf("Killed %d connections that didn't finish in time", report.Killed)
+	}
+
+	// the metrics and the control plane stay available while draining
+	if adminSrv != nil {
+		adminSrv.Shutdown(shutdownCtx)
+	}
+	if controlSrv != nil {
+		controlSrv.Shutdown(shutdownCtx)
+	}
 
 	wg.Wait()
 
+	select {
+	case err := <-serveFailed:
+		log.Fatal("Server failed: ", err)
+	default:
+	}
+
 	log.Info("Server stopped gracefully")
 }
 
-func SetLogLevel() {
-	switch os.Getenv("LOG_LEVEL") {
-	case "debug":
-		log.SetLevel(log.DebugLevel)
-	case "error":
-		log.SetLevel(log.ErrorLevel)
-	case "info":
-		log.SetLevel(log.InfoLevel)
-	case "warn":
-		log.SetLevel(log.WarnLevel)
+// setupLog configures the server log, the levels and the formats are validated by the config
+func setupLog(c config.Log) {
+	log.SetOutput(os.Stdout)
+	log.SetFormatter(logFormatter(c))
+
+	level, err := log.ParseLevel(c.Level)
+	if err != nil {
+		level = log.DebugLevel
+	}
+	log.SetLevel(level)
+}
+
+// newAuditLog returns the log of the control plane actions, it's written in the format
+// of the server log whatever the level of the server log is
+func newAuditLog(c config.Log) *log.Logger {
+	logger := log.New()
+	logger.SetOutput(os.Stdout)
+	logger.SetFormatter(logFormatter(c))
+	logger.SetLevel(log.InfoLevel)
+
+	return logger
+}
+
+func logFormatter(c config.Log) log.Formatter {
+	if c.Format == "text" {
+		return &log.TextFormatter{FullTimestamp: true}
+	}
+
+	return &log.JSONFormatter{}
+}
+
+// newAccessLog opens the output of the access log, whose records are always JSON lines.
+// It returns nil if the access log is disabled.
+func newAccessLog(c config.AccessLog) (*log.Logger, error) {
+	logger := log.New()
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.SetLevel(log.InfoLevel)
+
+	switch c.Output {
+	case "":
+		return nil, nil
+	case "stdout":
+		logger.SetOutput(os.Stdout)
+	case "stderr":
+		logger.SetOutput(os.Stderr)
 	default:
-		log.SetLevel(log.DebugLevel)
+		file, err := os.OpenFile(c.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetOutput(file)
 	}
+
+	return logger, nil
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/internal/certs"
+	"github.com/zhashkevych/quotes-server/internal/config"
+	quotes "github.com/zhashkevych/quotes-server/internal/quotes/yml"
+	"github.com/zhashkevych/quotes-server/internal/reputation"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+// reload loads the config again with the arguments and the environment of the process and swaps
+// in everything that can change while serving: the difficulty, the settings, the quotes, the bans,
+// the certificates and the log. The current config stays in place if the new one is invalid.
+func reload(current config.Config, srv *server.TCPServer, tracker *reputation.Tracker, tlsLoader *certs.Loader) (config.Config, error) {
+	loaded, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return current, err
+	}
+	cfg := loaded.Config
+
+	quotesService, err := quotes.NewYMLService(cfg.Quotes.File)
+	if err != nil {
+		return current, err
+	}
+
+	// the loader keeps the current certificate if the files are invalid
+	if tlsLoader != nil {
+		if err := tlsLoader.Reload(); err != nil {
+			return current, err
+		}
+	}
+
+	srv.Reload(server.LiveConfig{
+		PowDifficulty: cfg.PoW.Difficulty,
+		Settings:      cfg.Settings(),
+		Quotes:        quotesService,
+	})
+	tracker.Reconfigure(reputation.Config{
+		BanThreshold: cfg.Bans.Threshold,
+		BanDuration:  cfg.Bans.Duration,
+	})
+	setupLog(cfg.Log)
+
+	for _, key := range restartRequired(current, cfg) {
+		log.Warnf("%s changed, it takes effect after a restart (SIGUSR2)", key)
+	}
+
+	return cfg, nil
+}
+
+// restartRequired returns the sections of the config that changed but can't be reloaded
+func restartRequired(current, next config.Config) []string {
+	var keys []string
+	if !reflect.DeepEqual(current.Listen, next.Listen) {
+		keys = append(keys, "listen")
+	}
+	if current.Pool != next.Pool {
+		keys = append(keys, "pool")
+	}
+	if current.PoW.ChallengeSecret != next.PoW.ChallengeSecret {
+		keys = append(keys, "pow.challenge_secret")
+	}
+	if current.TLS != next.TLS {
+		keys = append(keys, "tls")
+	}
+	if current.Control != next.Control {
+		keys = append(keys, "control")
+	}
+	if current.AccessLog != next.AccessLog {
+		keys = append(keys, "access_log")
+	}
+	if current.PIDFile != next.PIDFile {
+		keys = append(keys, "pid_file")
+	}
+
+	return keys
+}
+
+// sendReload is the reload admin command. It validates the config the way the server will load it
+// and sends SIGHUP to the process in the PID file.
+func sendReload(args []string) error {
+	loaded, err := config.Load(args, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+
+	path := loaded.Config.PIDFile
+	if path == "" {
+		return fmt.Errorf("pid_file is not set, the server process can't be found")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid PID file %s: %q", path, data)
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		return fmt.Errorf("failed to signal the server process %d: %w", pid, err)
+	}
+
+	fmt.Printf("Sent reload to the server process %d\n", pid)
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/internal/handoff"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+// the names of the frontend sockets passed on restarts
+const (
+	httpSocketName    = "http"
+	udpSocketName     = "udp"
+	adminSocketName   = "admin"
+	controlSocketName = "control"
+)
+
+// listenerOptions configures the listeners of the TCP server. A listener takes the inherited socket
+// with its label instead of opening a new one, inherited sockets without a configured listener,
+// e.g. from socket activation, are served with the default policy. The default TCP listener
+// at the port is only opened when there are neither configured listeners nor inherited sockets.
+func listenerOptions(configs []server.ListenerConfig, port int, inherited *handoff.Inherited) ([]server.Option, error) {
+	if len(configs) == 0 && len(inherited.Names()) == 0 {
+		configs = append(configs, server.ListenerConfig{Label: "tcp", Network: "tcp", Address: fmt.Sprintf(":%d", port)})
+	}
+
+	var opts []server.Option
+	for _, config := range configs {
+		if config.Label == "" {
+			config.Label = config.Network
+		}
+
+		l, ok, err := inherited.Listener(config.Label)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			opts = append(opts, server.WithNetListener(config.Label, l, config.Policy))
+		} else {
+			opts = append(opts, server.WithListener(config))
+		}
+	}
+
+	for _, name := range inherited.Names() {
+		if name == httpSocketName || name == udpSocketName || name == adminSocketName || name == controlSocketName {
+			continue
+		}
+
+		l, _, err := inherited.Listener(name)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, server.WithNetListener(name, l, server.ListenerPolicy{}))
+	}
+
+	return opts, nil
+}
+
+// httpListener takes the inherited socket of the HTTP frontend or listens at the port
+func httpListener(port int, inherited *handoff.Inherited) (net.Listener, error) {
+	if l, ok, err := inherited.Listener(httpSocketName); ok || err != nil {
+		return l, err
+	}
+
+	return net.Listen("tcp", ":"+strconv.Itoa(port))
+}
+
+// adminListener takes the inherited socket of the admin listener or listens at the port
+func adminListener(port int, inherited *handoff.Inherited) (net.Listener, error) {
+	if l, ok, err := inherited.Listener(adminSocketName); ok || err != nil {
+		return l, err
+	}
+
+	return net.Listen("tcp", ":"+strconv.Itoa(port))
+}
+
+// controlListener takes the inherited socket of the control plane or listens on the host at the port
+func controlListener(host string, port int, inherited *handoff.Inherited) (net.Listener, error) {
+	if l, ok, err := inherited.Listener(controlSocketName); ok || err != nil {
+		return l, err
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// udpConn takes the inherited socket of the UDP mode or listens at the port
+func udpConn(port int, inherited *handoff.Inherited) (net.PacketConn, error) {
+	if conn, ok, err := inherited.PacketConn(udpSocketName); ok || err != nil {
+		return conn, err
+	}
+
+	return net.ListenPacket("udp", ":"+strconv.Itoa(port))
+}
+
+// restart passes the sockets to a new process of the server and waits until it's ready
+func restart(ctx context.Context, srv *server.TCPServer, httpL net.Listener, udpC net.PacketConn, adminL, controlL net.Listener) error {
+	files, err := srv.Files()
+	if err != nil {
+		return err
+	}
+
+	var sockets []handoff.Socket
+	for name, file := range files {
+		sockets = append(sockets, handoff.Socket{Name: name, File: file})
+	}
+
+	// the duplicates belong to the new process
+	defer func() {
+		for _, socket := range sockets {
+			socket.File.Close()
+		}
+	}()
+
+	frontends := []struct {
+		name   string
+		socket any
+	}{
+		{httpSocketName, httpL},
+		{udpSocketName, udpC},
+		{adminSocketName, adminL},
+		{controlSocketName, controlL},
+	}
+
+	for _, frontend := range frontends {
+		// a disabled frontend has no socket
+		if frontend.socket == nil {
+			continue
+		}
+
+		filer, ok := frontend.socket.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("socket %s has no file", frontend.name)
+		}
+
+		file, err := filer.File()
+		if err != nil {
+			return err
+		}
+		sockets = append(sockets, handoff.Socket{Name: frontend.name, File: file})
+	}
+
+	pid, err := handoff.Restart(ctx, sockets)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("New process %d is ready", pid)
+
+	return nil
+}
+
+// writePIDFile tells supervisors which process is serving after a restart
+func writePIDFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
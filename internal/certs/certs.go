@@ -0,0 +1,204 @@
+// Package certs loads TLS certificates for the server and the client,
+// reloads them when the files change and generates self-signed ones for development.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCheckInterval = time.Second
+
+// Config points to the PEM files of a TLS endpoint
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// CAFile is a bundle of CAs that verify the peer: client certificates on the server,
+	// which enables mutual TLS, and the server certificate on the client
+	CAFile string
+	// ServerName overrides the name the client verifies the server certificate against
+	ServerName string
+}
+
+// Loader keeps the certificate and the CA bundle loaded from files
+// and reloads them once the files change, so certificates can be rotated without a restart
+type Loader struct {
+	config        Config
+	checkInterval time.Duration
+
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+func NewLoader(config Config) (*Loader, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+
+	l := &Loader{config: config, checkInterval: defaultCheckInterval}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// NewServerConfig returns a TLS config serving the certificate from the files.
+// With a CA bundle, clients have to present a certificate signed by one of its CAs.
+func NewServerConfig(config Config) (*tls.Config, *Loader, error) {
+	if config.CertFile == "" {
+		return nil, nil, errors.New("server certificate file is not set")
+	}
+
+	l, err := NewLoader(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l.ServerConfig(), l, nil
+}
+
+// NewClientConfig returns a TLS config trusting the CA bundle (the system roots if not set)
+// and presenting the client certificate if one is set
+func NewClientConfig(config Config) (*tls.Config, error) {
+	l, err := NewLoader(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.ClientConfig(), nil
+}
+
+// ServerConfig returns a TLS config that picks up reloaded files on new handshakes
+func (l *Loader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, caPool := l.current()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if caPool != nil {
+				config.ClientCAs = caPool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig returns a TLS config that presents the current client certificate.
+// The CA bundle is read once.
+func (l *Loader) ClientConfig() *tls.Config {
+	_, caPool := l.current()
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: l.config.ServerName,
+		RootCAs:    caPool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := l.current()
+			if cert == nil {
+				// no certificate, the server decides whether that's acceptable
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+}
+
+// Reload reads the files again. The previous certificate stays in use if they are invalid.
+func (l *Loader) Reload() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.load()
+}
+
+// current returns the loaded certificate and CA pool, reloading them if the files have changed
+func (l *Loader) current() (*tls.Certificate, *x509.CertPool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCheck) >= l.checkInterval {
+		l.lastCheck = now
+
+		if l.changed() {
+			if err := l.load(); err != nil {
+				log.Errorf("failed to reload certificates: %s", err)
+			} else {
+				log.Info("certificates reloaded")
+			}
+		}
+	}
+
+	return l.cert, l.caPool
+}
+
+func (l *Loader) load() error {
+	var cert *tls.Certificate
+	if l.config.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(l.config.CertFile, l.config.KeyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to load certificate")
+		}
+		cert = &c
+	}
+
+	var caPool *x509.CertPool
+	if l.config.CAFile != "" {
+		pemData, err := os.ReadFile(l.config.CAFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read CA bundle")
+		}
+
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pemData) {
+			return errors.Errorf("no certificates found in %s", l.config.CAFile)
+		}
+	}
+
+	l.cert, l.caPool = cert, caPool
+	l.modTimes = l.statFiles()
+
+	return nil
+}
+
+func (l *Loader) changed() bool {
+	for file, modTime := range l.statFiles() {
+		if !modTime.Equal(l.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Loader) statFiles() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+
+	for _, file := range []string{l.config.CertFile, l.config.KeyFile, l.config.CAFile} {
+		if file == "" {
+			continue
+		}
+
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+
+	return modTimes
+}
@@ -0,0 +1,108 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// handshake connects a client and a server over a pipe and returns the server certificate the client got
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) ([]byte, error, error) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- tls.Server(serverSide, serverConfig).Handshake()
+		serverSide.Close()
+	}()
+
+	client := tls.Client(clientSide, clientConfig)
+	clientErr := client.Handshake()
+	clientSide.Close()
+
+	var raw []byte
+	if clientErr == nil {
+		raw = client.ConnectionState().PeerCertificates[0].Raw
+	}
+
+	return raw, clientErr, <-serverErr
+}
+
+func TestServerConfig_MutualTLS(t *testing.T) {
+	serverCert, serverKey, err := WriteSelfSigned(t.TempDir(), []string{"localhost"}, time.Hour)
+	assert.NoError(t, err)
+	clientCert, clientKey, err := WriteSelfSigned(t.TempDir(), []string{"client"}, time.Hour)
+	assert.NoError(t, err)
+
+	serverConfig, _, err := NewServerConfig(Config{CertFile: serverCert, KeyFile: serverKey, CAFile: clientCert})
+	assert.NoError(t, err)
+
+	// a client without a certificate is rejected
+	clientConfig, err := NewClientConfig(Config{CAFile: serverCert, ServerName: "localhost"})
+	assert.NoError(t, err)
+
+	_, _, serverErr := handshake(t, serverConfig, clientConfig)
+	assert.Error(t, serverErr)
+
+	clientConfig, err = NewClientConfig(Config{CertFile: clientCert, KeyFile: clientKey, CAFile: serverCert, ServerName: "localhost"})
+	assert.NoError(t, err)
+
+	_, clientErr, serverErr := handshake(t, serverConfig, clientConfig)
+	assert.NoError(t, clientErr)
+	assert.NoError(t, serverErr)
+}
+
+func TestLoader_HotReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := WriteSelfSigned(dir, []string{"localhost"}, time.Hour)
+	assert.NoError(t, err)
+
+	loader, err := NewLoader(Config{CertFile: certFile, KeyFile: keyFile})
+	assert.NoError(t, err)
+	loader.checkInterval = 0
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+
+	before, clientErr, _ := handshake(t, loader.ServerConfig(), clientConfig)
+	assert.NoError(t, clientErr)
+
+	// rotate the certificate
+	rotated := t.TempDir()
+	newCert, newKey, err := WriteSelfSigned(rotated, []string{"localhost"}, time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Rename(newCert, certFile))
+	assert.NoError(t, os.Rename(newKey, keyFile))
+
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, future, future))
+
+	after, clientErr, _ := handshake(t, loader.ServerConfig(), clientConfig)
+	assert.NoError(t, clientErr)
+	assert.NotEqual(t, before, after)
+
+	// a broken file keeps the previous certificate in use
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, KeyFileName), []byte("broken"), 0600))
+	assert.NoError(t, os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute)))
+
+	kept, clientErr, _ := handshake(t, loader.ServerConfig(), clientConfig)
+	assert.NoError(t, clientErr)
+	assert.Equal(t, after, kept)
+}
+
+func TestNewLoader_Invalid(t *testing.T) {
+	_, err := NewLoader(Config{CertFile: "cert.pem"})
+	assert.Error(t, err)
+
+	_, _, err = NewServerConfig(Config{})
+	assert.Error(t, err)
+
+	_, err = NewLoader(Config{CertFile: "missing.pem", KeyFile: "missing.pem"})
+	assert.Error(t, err)
+}
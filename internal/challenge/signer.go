@@ -0,0 +1,160 @@
+// Package challenge issues stateless proof-of-work challenges for frontends
+// that can't keep a challenge per connection, such as HTTP and UDP.
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalid  = errors.New("invalid challenge")
+	ErrExpired  = errors.New("challenge expired")
+	ErrReplayed = errors.New("challenge has already been used")
+)
+
+// Signer appends an expiry and an HMAC to a "seed:difficulty" challenge:
+// "seed:difficulty:expiry:mac". Hashcash solvers only look at the first two fields,
+// so a signed challenge is solved the same way as a plain one.
+// A solved challenge can be redeemed once, the signer remembers redeemed challenges until they expire.
+type Signer struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+
+	redeemed    map[string]time.Time
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// NewSigner creates a signer with the key. An empty key is replaced with a random one,
+// which is fine unless several servers have to accept each other's challenges.
+func NewSigner(key []byte, ttl time.Duration) (*Signer, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, errors.Wrap(err, "failed to generate signing key")
+		}
+	}
+
+	return &Signer{
+		key:      key,
+		ttl:      ttl,
+		now:      time.Now,
+		redeemed: make(map[string]time.Time),
+	}, nil
+}
+
+// Sign returns the challenge with the expiry and the signature appended
+func (s *Signer) Sign(challenge string) string {
+	return s.SignWithTTL(challenge, s.ttl)
+}
+
+// SignWithTTL is Sign with a lifetime other than the default one, e.g. scaled to the difficulty
+func (s *Signer) SignWithTTL(challenge string, ttl time.Duration) string {
+	return s.SignFor(challenge, "", ttl)
+}
+
+// SignFor signs a challenge that is only valid when presented by the subject, e.g. a client address.
+// The subject is covered by the signature but not included in the challenge.
+func (s *Signer) SignFor(challenge, subject string, ttl time.Duration) string {
+	expiry := strconv.FormatInt(s.now().Add(ttl).Unix(), 10)
+	payload := challenge + ":" + expiry
+
+	return payload + ":" + s.mac(payload, subject)
+}
+
+// Verify checks the signature and the expiry of a signed challenge
+// and returns the original challenge and its expiry
+func (s *Signer) Verify(signed string) (string, time.Time, error) {
+	return s.VerifyFor(signed, "")
+}
+
+// VerifyFor is Verify for a challenge signed for the subject
+func (s *Signer) VerifyFor(signed, subject string) (string, time.Time, error) {
+	i := strings.LastIndexByte(signed, ':')
+	if i < 0 {
+		return "", time.Time{}, ErrInvalid
+	}
+	payload, mac := signed[:i], signed[i+1:]
+
+	if !hmac.Equal([]byte(mac), []byte(s.mac(payload, subject))) {
+		return "", time.Time{}, ErrInvalid
+	}
+
+	i = strings.LastIndexByte(payload, ':')
+	if i < 0 {
+		return "", time.Time{}, ErrInvalid
+	}
+
+	unix, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalid
+	}
+
+	expiry := time.Unix(unix, 0)
+	if !s.now().Before(expiry) {
+		return "", time.Time{}, ErrExpired
+	}
+
+	return payload[:i], expiry, nil
+}
+
+// Redeem marks a verified signed challenge as used, failing if it has been used before
+func (s *Signer) Redeem(signed string) error {
+	return s.RedeemFor(signed, "")
+}
+
+// RedeemFor is Redeem for a challenge signed for the subject
+func (s *Signer) RedeemFor(signed, subject string) error {
+	_, expiry, err := s.VerifyFor(signed, subject)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cleanup()
+
+	if _, ok := s.redeemed[signed]; ok {
+		return ErrReplayed
+	}
+	s.redeemed[signed] = expiry
+
+	return nil
+}
+
+// cleanup forgets the redeemed challenges that have expired, at most once per ttl
+func (s *Signer) cleanup() {
+	now := s.now()
+	if now.Sub(s.lastCleanup) < s.ttl {
+		return
+	}
+	s.lastCleanup = now
+
+	for signed, expiry := range s.redeemed {
+		if !now.Before(expiry) {
+			delete(s.redeemed, signed)
+		}
+	}
+}
+
+func (s *Signer) mac(payload, subject string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	if subject != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(subject))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package challenge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/pkg/hashcash"
+)
+
+func TestSigner_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	signer, err := NewSigner([]byte("secret"), time.Minute)
+	assert.NoError(t, err)
+	signer.now = func() time.Time { return now }
+
+	signed := signer.Sign("seed:4")
+	assert.Equal(t, "seed:4:1700000060:", signed[:len("seed:4:1700000060:")])
+
+	challenge, expiry, err := signer.Verify(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "seed:4", challenge)
+	assert.Equal(t, now.Add(time.Minute), expiry)
+
+	// a lower difficulty or a later expiry breaks the signature
+	for _, tampered := range []string{
+		"seed:3" + signed[len("seed:4"):],
+		"seed:4:1700009999" + signed[len("seed:4:1700000060"):],
+		"seed:4",
+		"",
+	} {
+		_, _, err := signer.Verify(tampered)
+		assert.ErrorIs(t, err, ErrInvalid)
+	}
+
+	other, err := NewSigner([]byte("other secret"), time.Minute)
+	assert.NoError(t, err)
+	_, _, err = other.Verify(signed)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	now = now.Add(time.Minute)
+	_, _, err = signer.Verify(signed)
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestSigner_Redeem(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	signer, err := NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+	signer.now = func() time.Time { return now }
+
+	signed := signer.Sign("seed:4")
+
+	assert.NoError(t, signer.Redeem(signed))
+	assert.ErrorIs(t, signer.Redeem(signed), ErrReplayed)
+	assert.NoError(t, signer.Redeem(signer.Sign("other:4")))
+
+	// expired challenges are forgotten
+	now = now.Add(2 * time.Minute)
+	assert.ErrorIs(t, signer.Redeem(signed), ErrExpired)
+	assert.NoError(t, signer.Redeem(signer.Sign("fresh:4")))
+	assert.Len(t, signer.redeemed, 1)
+}
+
+func TestSigner_Subject(t *testing.T) {
+	signer, err := NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	signed := signer.SignFor("seed:4", "192.0.2.1", time.Minute)
+
+	challenge, _, err := signer.VerifyFor(signed, "192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "seed:4", challenge)
+
+	_, _, err = signer.VerifyFor(signed, "192.0.2.2")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, _, err = signer.Verify(signed)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	assert.ErrorIs(t, signer.RedeemFor(signed, "192.0.2.2"), ErrInvalid)
+	assert.NoError(t, signer.RedeemFor(signed, "192.0.2.1"))
+	assert.ErrorIs(t, signer.RedeemFor(signed, "192.0.2.1"), ErrReplayed)
+}
+
+func TestSigner_Hashcash(t *testing.T) {
+	signer, err := NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	pow := hashcash.New()
+	challenge, err := pow.GenerateChallenge(2)
+	assert.NoError(t, err)
+
+	// hashcash solves and verifies a signed challenge like the plain one
+	signed := signer.Sign(challenge)
+	nonce, err := pow.SolveChallenge(signed)
+	assert.NoError(t, err)
+
+	valid, err := pow.VerifySolution(challenge, nonce)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
@@ -0,0 +1,447 @@
+// Package config loads the server configuration from a YAML file, the environment
+// and the command line. Each layer overrides the previous one:
+//
+//	defaults < config file < environment variables < command line flags
+//
+// The config file is given with --config or CONFIG_FILE. Every setting has a dotted
+// key, e.g. pow.difficulty, which is its path in the file and its flag name.
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zhashkevych/quotes-server/internal/proxyproto"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+// Config is the effective configuration of the server
+type Config struct {
+	Listen  Listen  `yaml:"listen"`
+	PoW     PoW     `yaml:"pow"`
+	Session Session `yaml:"session"`
+	Limits  Limits  `yaml:"limits"`
+	Pool    Pool    `yaml:"pool"`
+	Bans    Bans    `yaml:"bans"`
+	Quotes  Quotes  `yaml:"quotes"`
+	TLS     TLS     `yaml:"tls"`
+	Log     Log     `yaml:"log"`
+	// AccessLog is the log of one record per TCP or WebSocket connection
+	AccessLog AccessLog `yaml:"access_log"`
+	Control   Control   `yaml:"control"`
+	// PIDFile is rewritten by every process that takes over serving
+	PIDFile string `yaml:"pid_file"`
+}
+
+// Listen configures where the server accepts clients
+type Listen struct {
+	// Port is the port of the default TCP listener
+	Port int `yaml:"port"`
+	// Listeners replace the default listener, see server.ParseListenerConfig for the format
+	Listeners []string `yaml:"listeners"`
+	// ProxyProtocol lists the networks whose PROXY protocol headers are trusted
+	ProxyProtocol []string `yaml:"proxy_protocol"`
+	// HTTPPort is the port of the HTTP frontend, zero disables it
+	HTTPPort int `yaml:"http_port"`
+	// UDPPort is the port of the UDP mode, zero disables it
+	UDPPort int `yaml:"udp_port"`
+	// AdminPort is the port of the admin HTTP listener serving /metrics, /healthz and /readyz, zero disables it
+	AdminPort int `yaml:"admin_port"`
+	// ControlPort is the port of the authenticated control plane, zero disables it
+	ControlPort int `yaml:"control_port"`
+}
+
+// PoW configures the challenges
+type PoW struct {
+	Difficulty       int           `yaml:"difficulty"`
+	SolutionAttempts int           `yaml:"solution_attempts"`
+	SolveTimeout     time.Duration `yaml:"solve_timeout"`
+	SolveHashRate    float64       `yaml:"solve_hash_rate"`
+	MaxSolveTimeout  time.Duration `yaml:"max_solve_timeout"`
+	// ChallengeSecret signs the stateless challenges of the HTTP and UDP frontends, random if empty
+	ChallengeSecret string `yaml:"challenge_secret"`
+}
+
+// Session configures the keep-alive command sessions
+type Session struct {
+	KeepAlive bool `yaml:"keep_alive"`
+	// PowGate is command or credit
+	PowGate            string `yaml:"pow_gate"`
+	CreditsPerSolution int    `yaml:"credits_per_solution"`
+}
+
+// Limits bound the time and the resources a client may take
+type Limits struct {
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout"`
+	WriteTimeout     time.Duration `yaml:"write_timeout"`
+	IdleGap          time.Duration `yaml:"idle_gap"`
+	// MinThroughput is in bytes per second
+	MinThroughput int `yaml:"min_throughput"`
+	// PressureConnections of zero disables shedding of stalled connections
+	PressureConnections int           `yaml:"pressure_connections"`
+	PressureIdleGap     time.Duration `yaml:"pressure_idle_gap"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout"`
+	// MaxRequestSize bounds a request line and a datagram in bytes
+	MaxRequestSize int `yaml:"max_request_size"`
+}
+
+// Pool bounds the goroutines serving connections
+type Pool struct {
+	// Workers of zero serve every connection in a goroutine of its own
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+	// Overload is reject or wait
+	Overload     string        `yaml:"overload"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+}
+
+// Bans configure the reputation tracker
+type Bans struct {
+	// Threshold is the number of failed attempts per minute, zero disables bans
+	Threshold int           `yaml:"threshold"`
+	Duration  time.Duration `yaml:"duration"`
+}
+
+// Quotes configures the quote source
+type Quotes struct {
+	File string `yaml:"file"`
+}
+
+// TLS enables TLS when CertFile is set and mutual TLS when ClientCAFile is set
+type TLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// Log configures the server log
+type Log struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is json or text
+	Format string `yaml:"format"`
+}
+
+// AccessLog configures the access log
+type AccessLog struct {
+	// Output is stdout, stderr or a file the records are appended to, empty disables the access log.
+	// It's disabled by default, as the server log goes to stdout. Only the connections of the TCP
+	// listeners and the WebSocket sessions are logged, the HTTP and UDP frontends have no records.
+	Output string `yaml:"output"`
+	// SampleRate is the share of the connections that are logged, from 0 to 1
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+// Control configures the control plane
+type Control struct {
+	// Host is the address the control plane listens on. It's loopback by default,
+	// as the token is sent in plaintext.
+	Host string `yaml:"host"`
+	// Token is the bearer token of the requests
+	Token string `yaml:"token"`
+}
+
+// Default returns the configuration of the server without any settings
+func Default() Config {
+	settings := server.DefaultSettings()
+	pool := server.DefaultPoolPolicy()
+
+	return Config{
+		Listen: Listen{
+			Port: 9000,
+		},
+		PoW: PoW{
+			Difficulty:       server.DefaultPowDifficulty,
+			SolutionAttempts: settings.SolutionAttempts,
+			SolveTimeout:     settings.Timeouts.SolveBase,
+			SolveHashRate:    settings.Timeouts.SolveHashRate,
+			MaxSolveTimeout:  settings.Timeouts.SolveMax,
+		},
+		Session: Session{
+			KeepAlive:          settings.KeepAlive,
+			PowGate:            "command",
+			CreditsPerSolution: settings.CreditsPerSolution,
+		},
+		Limits: Limits{
+			HandshakeTimeout:    settings.Timeouts.Handshake,
+			WriteTimeout:        settings.Timeouts.Write,
+			IdleGap:             settings.SlowClients.IdleGap,
+			MinThroughput:       settings.SlowClients.MinThroughput,
+			PressureConnections: settings.SlowClients.PressureConnections,
+			PressureIdleGap:     settings.SlowClients.PressureIdleGap,
+			ShutdownTimeout:     30 * time.Second,
+			MaxRequestSize:      settings.MaxRequestSize,
+		},
+		Pool: Pool{
+			Workers:      pool.Workers,
+			QueueSize:    pool.QueueSize,
+			Overload:     "reject",
+			QueueTimeout: pool.QueueTimeout,
+		},
+		Bans: Bans{
+			Duration: 10 * time.Minute,
+		},
+		Quotes: Quotes{
+			File: "./quotes.yml",
+		},
+		Log: Log{
+			Level:  "debug",
+			Format: "json",
+		},
+		AccessLog: AccessLog{
+			SampleRate: 1,
+		},
+		Control: Control{
+			Host: "127.0.0.1",
+		},
+	}
+}
+
+// FieldError is an invalid setting
+type FieldError struct {
+	// Key is the dotted key of the setting
+	Key string
+	// Source is where the value comes from: a file, a variable, a flag or the defaults
+	Source string
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("%s: %v", e.Key, e.Err)
+	}
+
+	return fmt.Sprintf("%s (from %s): %v", e.Key, e.Source, e.Err)
+}
+
+// Errors are all the problems found in a configuration
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, "invalid configuration:")
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the whole configuration and reports every invalid setting.
+// sources maps the dotted keys to where their values come from, it may be nil.
+func (c *Config) Validate(sources map[string]string) error {
+	var errs Errors
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, &FieldError{Key: key, Source: sources[key], Err: err})
+		}
+	}
+
+	check("listen.port", port(c.Listen.Port))
+	check("listen.http_port", port(c.Listen.HTTPPort))
+	check("listen.udp_port", port(c.Listen.UDPPort))
+	check("listen.admin_port", port(c.Listen.AdminPort))
+	check("listen.control_port", port(c.Listen.ControlPort))
+	if c.Listen.ControlPort != 0 && c.Control.Token == "" {
+		check("control.token", fmt.Errorf("must be set with listen.control_port"))
+	}
+
+	labels := make(map[string]bool)
+	for _, spec := range c.Listen.Listeners {
+		config, err := server.ParseListenerConfig(spec)
+		if err == nil {
+			label := config.Label
+			if label == "" {
+				label = config.Network
+			}
+			if labels[label] {
+				err = fmt.Errorf("listener label %q is used twice", label)
+			}
+			labels[label] = true
+		}
+		check("listen.listeners", err)
+	}
+
+	_, err := c.ProxyTrusted()
+	check("listen.proxy_protocol", err)
+
+	// a difficulty can't exceed the length of a hex SHA-256 digest
+	check("pow.difficulty", between(c.PoW.Difficulty, 1, 64))
+	check("pow.solution_attempts", atLeast(c.PoW.SolutionAttempts, 1))
+	check("pow.solve_timeout", positive(c.PoW.SolveTimeout))
+	if c.PoW.SolveHashRate <= 0 {
+		check("pow.solve_hash_rate", fmt.Errorf("must be positive, got %v", c.PoW.SolveHashRate))
+	}
+	check("pow.max_solve_timeout", positive(c.PoW.MaxSolveTimeout))
+	if c.PoW.MaxSolveTimeout > 0 && c.PoW.MaxSolveTimeout < c.PoW.SolveTimeout {
+		check("pow.max_solve_timeout", fmt.Errorf("must not be shorter than pow.solve_timeout %s", c.PoW.SolveTimeout))
+	}
+
+	check("session.pow_gate", oneOf(c.Session.PowGate, "command", "credit"))
+	check("session.credits_per_solution", atLeast(c.Session.CreditsPerSolution, 1))
+
+	check("limits.handshake_timeout", positive(c.Limits.HandshakeTimeout))
+	check("limits.write_timeout", positive(c.Limits.WriteTimeout))
+	check("limits.idle_gap", positive(c.Limits.IdleGap))
+	check("limits.min_throughput", atLeast(c.Limits.MinThroughput, 1))
+	check("limits.pressure_connections", atLeast(c.Limits.PressureConnections, 0))
+	check("limits.pressure_idle_gap", positive(c.Limits.PressureIdleGap))
+	check("limits.shutdown_timeout", positive(c.Limits.ShutdownTimeout))
+	// a request has to fit a challenge and a nonce
+	check("limits.max_request_size", between(c.Limits.MaxRequestSize, 64, 64*1024))
+
+	check("pool.workers", atLeast(c.Pool.Workers, 0))
+	check("pool.queue_size", atLeast(c.Pool.QueueSize, 0))
+	check("pool.overload", oneOf(c.Pool.Overload, "reject", "wait"))
+	check("pool.queue_timeout", positive(c.Pool.QueueTimeout))
+
+	check("bans.threshold", atLeast(c.Bans.Threshold, 0))
+	check("bans.duration", positive(c.Bans.Duration))
+
+	if c.Quotes.File == "" {
+		check("quotes.file", fmt.Errorf("must be set"))
+	} else if _, err := os.Stat(c.Quotes.File); err != nil {
+		check("quotes.file", err)
+	}
+
+	if c.TLS.CertFile != "" && c.TLS.KeyFile == "" {
+		check("tls.key_file", fmt.Errorf("must be set with tls.cert_file"))
+	}
+	if c.TLS.CertFile == "" && c.TLS.KeyFile != "" {
+		check("tls.cert_file", fmt.Errorf("must be set with tls.key_file"))
+	}
+	if c.TLS.CertFile == "" && c.TLS.ClientCAFile != "" {
+		check("tls.client_ca_file", fmt.Errorf("requires tls.cert_file, mutual TLS is on top of TLS"))
+	}
+
+	check("log.level", oneOf(c.Log.Level, "debug", "info", "warn", "error"))
+	check("log.format", oneOf(c.Log.Format, "json", "text"))
+	if c.AccessLog.SampleRate < 0 || c.AccessLog.SampleRate > 1 {
+		check("access_log.sample_rate", fmt.Errorf("must be between 0 and 1, got %v", c.AccessLog.SampleRate))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Settings returns the server settings of the config
+func (c *Config) Settings() server.Settings {
+	settings := server.DefaultSettings()
+
+	settings.KeepAlive = c.Session.KeepAlive
+	if c.Session.PowGate == "credit" {
+		settings.PowGate = server.GatePerCredit
+	}
+	settings.CreditsPerSolution = c.Session.CreditsPerSolution
+	settings.SolutionAttempts = c.PoW.SolutionAttempts
+
+	settings.Timeouts = server.Timeouts{
+		Handshake:     c.Limits.HandshakeTimeout,
+		SolveBase:     c.PoW.SolveTimeout,
+		SolveHashRate: c.PoW.SolveHashRate,
+		SolveMax:      c.PoW.MaxSolveTimeout,
+		Write:         c.Limits.WriteTimeout,
+	}
+
+	settings.SlowClients.IdleGap = c.Limits.IdleGap
+	settings.SlowClients.MinThroughput = c.Limits.MinThroughput
+	settings.SlowClients.PressureConnections = c.Limits.PressureConnections
+	settings.SlowClients.PressureIdleGap = c.Limits.PressureIdleGap
+	settings.MaxRequestSize = c.Limits.MaxRequestSize
+
+	return settings
+}
+
+// PoolPolicy returns the worker pool policy of the config
+func (c *Config) PoolPolicy() server.PoolPolicy {
+	policy := server.PoolPolicy{
+		Workers:      c.Pool.Workers,
+		QueueSize:    c.Pool.QueueSize,
+		Overload:     server.OverloadReject,
+		QueueTimeout: c.Pool.QueueTimeout,
+	}
+	if c.Pool.Overload == "wait" {
+		policy.Overload = server.OverloadWait
+	}
+
+	return policy
+}
+
+// ListenerConfigs parses the configured listeners
+func (c *Config) ListenerConfigs() ([]server.ListenerConfig, error) {
+	var configs []server.ListenerConfig
+	for _, spec := range c.Listen.Listeners {
+		config, err := server.ParseListenerConfig(spec)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+// ProxyTrusted parses the networks whose PROXY protocol headers are trusted
+func (c *Config) ProxyTrusted() ([]*net.IPNet, error) {
+	if len(c.Listen.ProxyProtocol) == 0 {
+		return nil, nil
+	}
+
+	return proxyproto.ParseCIDRs(strings.Join(c.Listen.ProxyProtocol, ","))
+}
+
+// Redacted returns a copy of the config that is safe to print
+func (c Config) Redacted() Config {
+	if c.PoW.ChallengeSecret != "" {
+		c.PoW.ChallengeSecret = "<redacted>"
+	}
+	if c.Control.Token != "" {
+		c.Control.Token = "<redacted>"
+	}
+
+	return c
+}
+
+func port(n int) error {
+	return between(n, 0, 65535)
+}
+
+func between(n, min, max int) error {
+	if n < min || n > max {
+		return fmt.Errorf("must be between %d and %d, got %d", min, max, n)
+	}
+
+	return nil
+}
+
+func atLeast(n, min int) error {
+	if n < min {
+		return fmt.Errorf("must be at least %d, got %d", min, n)
+	}
+
+	return nil
+}
+
+func positive(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("must be positive, got %s", d)
+	}
+
+	return nil
+}
+
+func oneOf(value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be one of %s, got %q", strings.Join(allowed, "|"), value)
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+const quotesFile = "../../quotes.yml"
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+pow:
+  difficulty: 5
+  solve_timeout: 10s
+session:
+  keep_alive: true
+limits:
+  idle_gap: 2s
+quotes:
+  file: `+quotesFile+`
+`)
+
+	res, err := Load(
+		[]string{"--config", path, "--limits.idle_gap=3s"},
+		env(map[string]string{"POW_DIFFICULTY": "6", "IDLE_GAP": "5s", "BAN_THRESHOLD": ""}),
+	)
+	assert.NoError(t, err)
+
+	cfg := res.Config
+	// the file overrides the defaults, the variables the file and the flags everything
+	assert.Equal(t, 6, cfg.PoW.Difficulty)
+	assert.Equal(t, 10*time.Second, cfg.PoW.SolveTimeout)
+	assert.True(t, cfg.Session.KeepAlive)
+	assert.Equal(t, 3*time.Second, cfg.Limits.IdleGap)
+	assert.Equal(t, Default().Limits.WriteTimeout, cfg.Limits.WriteTimeout)
+	// the control plane stays on loopback unless it's told otherwise
+	assert.Equal(t, "127.0.0.1", cfg.Control.Host)
+	// the access log isn't mixed with the server log unless it's told to
+	assert.Empty(t, cfg.AccessLog.Output)
+
+	assert.Equal(t, "variable POW_DIFFICULTY", res.Sources["pow.difficulty"])
+	assert.Equal(t, "file "+path, res.Sources["pow.solve_timeout"])
+	assert.Equal(t, "flag --limits.idle_gap", res.Sources["limits.idle_gap"])
+	// empty variables are unset
+	assert.NotContains(t, res.Sources, "bans.threshold")
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	path := writeFile(t, `
+listen:
+  listeners:
+    - public=tcp://:9000
+    - unix:///tmp/quotes.sock?pow=off
+`)
+
+	res, err := Load([]string{"--print-config"}, env(map[string]string{"CONFIG_FILE": path, "QUOTES_FILEPATH": quotesFile}))
+	assert.NoError(t, err)
+	assert.True(t, res.PrintConfig)
+
+	configs, err := res.Config.ListenerConfigs()
+	assert.NoError(t, err)
+	assert.Len(t, configs, 2)
+	assert.True(t, configs[1].Policy.SkipPoW)
+}
+
+func TestLoad_ReportsEveryError(t *testing.T) {
+	path := writeFile(t, `
+pow:
+  difficulty: five
+  dificulty: 3
+log:
+  level: loud
+`)
+
+	_, err := Load(
+		[]string{"--config", path, "--session.pow_gate=both", "--limits.write_timeout=soon"},
+		env(map[string]string{"LISTENERS": "public=tcp://:9000,public=unix:///tmp/q.sock", "BAN_THRESHOLD": "-1", "QUOTES_FILEPATH": "missing.yml", "ACCESS_LOG_SAMPLE_RATE": "1.5", "CONTROL_PORT": "9200"}),
+	)
+
+	errs, ok := err.(Errors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 10)
+
+	msg := err.Error()
+	assert.Contains(t, msg, "cannot unmarshal !!str `five` into int")
+	assert.Contains(t, msg, "field dificulty not found")
+	assert.Contains(t, msg, `log.level (from file `+path+`): must be one of debug|info|warn|error, got "loud"`)
+	assert.Contains(t, msg, `session.pow_gate (from flag --session.pow_gate): must be one of command|credit, got "both"`)
+	assert.Contains(t, msg, `limits.write_timeout (from flag --limits.write_timeout): "soon" is not a duration`)
+	assert.Contains(t, msg, `listener label "public" is used twice`)
+	assert.Contains(t, msg, "bans.threshold (from variable BAN_THRESHOLD): must be at least 0, got -1")
+	assert.Contains(t, msg, "quotes.file (from variable QUOTES_FILEPATH)")
+	assert.Contains(t, msg, "access_log.sample_rate (from variable ACCESS_LOG_SAMPLE_RATE): must be between 0 and 1, got 1.5")
+	assert.Contains(t, msg, "control.token: must be set with listen.control_port")
+}
+
+func TestLoad_Example(t *testing.T) {
+	res, err := Load([]string{"--config", "../../config.example.yml"}, env(map[string]string{"QUOTES_FILEPATH": quotesFile}))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, res.Config.Bans.Threshold)
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := Default()
+	cfg.PoW.ChallengeSecret = "secret"
+	cfg.Control.Token = "token"
+
+	assert.Equal(t, "<redacted>", cfg.Redacted().PoW.ChallengeSecret)
+	assert.Equal(t, "<redacted>", cfg.Redacted().Control.Token)
+	assert.Equal(t, "secret", cfg.PoW.ChallengeSecret)
+}
+
+func TestConfig_Settings(t *testing.T) {
+	cfg := Default()
+	cfg.Session.KeepAlive = true
+	cfg.Session.PowGate = "credit"
+	cfg.Limits.IdleGap = 3 * time.Second
+
+	settings := cfg.Settings()
+	assert.True(t, settings.KeepAlive)
+	assert.Equal(t, server.GatePerCredit, settings.PowGate)
+	assert.Equal(t, 3*time.Second, settings.SlowClients.IdleGap)
+	// the defaults are the server's
+	defaults := Default()
+	assert.Equal(t, server.DefaultSettings(), defaults.Settings())
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const envConfigFile = "CONFIG_FILE"
+
+// field is a setting that can be overridden by a variable and a flag
+type field struct {
+	key   string
+	env   string
+	usage string
+	value func(c *Config) any
+}
+
+var fields = []field{
+	{"listen.port", "LISTEN_PORT", "port of the default TCP listener", func(c *Config) any { return &c.Listen.Port }},
+	{"listen.listeners", "LISTENERS", "comma-separated listeners, [label=]network://address[?options]", func(c *Config) any { return &c.Listen.Listeners }},
+	{"listen.proxy_protocol", "PROXY_PROTOCOL_CIDRS", "comma-separated networks whose PROXY protocol headers are trusted", func(c *Config) any { return &c.Listen.ProxyProtocol }},
+	{"listen.http_port", "HTTP_PORT", "port of the HTTP frontend, 0 disables it", func(c *Config) any { return &c.Listen.HTTPPort }},
+	{"listen.udp_port", "UDP_PORT", "port of the UDP mode, 0 disables it", func(c *Config) any { return &c.Listen.UDPPort }},
+	{"listen.admin_port", "ADMIN_PORT", "port of the admin HTTP listener serving /metrics, /healthz and /readyz, 0 disables it", func(c *Config) any { return &c.Listen.AdminPort }},
+	{"listen.control_port", "CONTROL_PORT", "port of the authenticated control plane, 0 disables it", func(c *Config) any { return &c.Listen.ControlPort }},
+	{"pow.difficulty", "POW_DIFFICULTY", "number of leading zero hex digits of a solution", func(c *Config) any { return &c.PoW.Difficulty }},
+	{"pow.solution_attempts", "SOLUTION_ATTEMPTS", "attempts to solve the same challenge", func(c *Config) any { return &c.PoW.SolutionAttempts }},
+	{"pow.solve_timeout", "SOLVE_TIMEOUT", "solve window of a trivial challenge", func(c *Config) any { return &c.PoW.SolveTimeout }},
+	{"pow.solve_hash_rate", "SOLVE_HASH_RATE", "hashes per second of a modest client", func(c *Config) any { return &c.PoW.SolveHashRate }},
+	{"pow.max_solve_timeout", "MAX_SOLVE_TIMEOUT", "cap of the solve window", func(c *Config) any { return &c.PoW.MaxSolveTimeout }},
+	{"pow.challenge_secret", "CHALLENGE_SECRET", "signing key of HTTP and UDP challenges, random if empty", func(c *Config) any { return &c.PoW.ChallengeSecret }},
+	{"session.keep_alive", "KEEP_ALIVE", "allow command sessions", func(c *Config) any { return &c.Session.KeepAlive }},
+	{"session.pow_gate", "POW_GATE", "what a solution pays for in a session, command|credit", func(c *Config) any { return &c.Session.PowGate }},
+	{"session.credits_per_solution", "CREDITS_PER_SOLUTION", "quotes a solution buys with the credit gate", func(c *Config) any { return &c.Session.CreditsPerSolution }},
+	{"limits.handshake_timeout", "HANDSHAKE_TIMEOUT", "deadline of a request that is not a solution", func(c *Config) any { return &c.Limits.HandshakeTimeout }},
+	{"limits.write_timeout", "WRITE_TIMEOUT", "deadline of a response", func(c *Config) any { return &c.Limits.WriteTimeout }},
+	{"limits.idle_gap", "IDLE_GAP", "longest pause between bytes of a request", func(c *Config) any { return &c.Limits.IdleGap }},
+	{"limits.min_throughput", "MIN_THROUGHPUT", "minimum rate of a request in bytes per second", func(c *Config) any { return &c.Limits.MinThroughput }},
+	{"limits.pressure_connections", "PRESSURE_CONNECTIONS", "open connections that put the server under pressure, 0 disables shedding", func(c *Config) any { return &c.Limits.PressureConnections }},
+	{"limits.pressure_idle_gap", "PRESSURE_IDLE_GAP", "idle gap under pressure", func(c *Config) any { return &c.Limits.PressureIdleGap }},
+	{"limits.shutdown_timeout", "SHUTDOWN_TIMEOUT", "time in-flight exchanges get to finish on shutdown", func(c *Config) any { return &c.Limits.ShutdownTimeout }},
+	{"limits.max_request_size", "MAX_REQUEST_SIZE", "longest request line or datagram in bytes", func(c *Config) any { return &c.Limits.MaxRequestSize }},
+	{"pool.workers", "POOL_WORKERS", "connections served at once, 0 serves every connection in a goroutine of its own", func(c *Config) any { return &c.Pool.Workers }},
+	{"pool.queue_size", "POOL_QUEUE_SIZE", "accepted connections that may wait for a worker", func(c *Config) any { return &c.Pool.QueueSize }},
+	{"pool.overload", "POOL_OVERLOAD", "what happens to connections while the queue is full: reject or wait", func(c *Config) any { return &c.Pool.Overload }},
+	{"pool.queue_timeout", "POOL_QUEUE_TIMEOUT", "how long the wait overload mode holds a connection for a place in the queue", func(c *Config) any { return &c.Pool.QueueTimeout }},
+	{"bans.threshold", "BAN_THRESHOLD", "failed attempts per minute that get a client banned, 0 disables bans", func(c *Config) any { return &c.Bans.Threshold }},
+	{"bans.duration", "BAN_DURATION", "duration of a ban", func(c *Config) any { return &c.Bans.Duration }},
+	{"quotes.file", "QUOTES_FILEPATH", "YAML file with the quotes", func(c *Config) any { return &c.Quotes.File }},
+	{"tls.cert_file", "TLS_CERT_FILE", "certificate, enables TLS", func(c *Config) any { return &c.TLS.CertFile }},
+	{"tls.key_file", "TLS_KEY_FILE", "private key of the certificate", func(c *Config) any { return &c.TLS.KeyFile }},
+	{"tls.client_ca_file", "TLS_CLIENT_CA_FILE", "CA of client certificates, enables mutual TLS", func(c *Config) any { return &c.TLS.ClientCAFile }},
+	{"log.level", "LOG_LEVEL", "debug|info|warn|error", func(c *Config) any { return &c.Log.Level }},
+	{"log.format", "LOG_FORMAT", "json|text", func(c *Config) any { return &c.Log.Format }},
+	{"access_log.output", "ACCESS_LOG_OUTPUT", "stdout|stderr|<file>, empty (the default) disables the access log", func(c *Config) any { return &c.AccessLog.Output }},
+	{"access_log.sample_rate", "ACCESS_LOG_SAMPLE_RATE", "share of the connections in the access log, from 0 to 1", func(c *Config) any { return &c.AccessLog.SampleRate }},
+	{"control.host", "CONTROL_HOST", "address the control plane listens on, empty for all interfaces", func(c *Config) any { return &c.Control.Host }},
+	{"control.token", "CONTROL_TOKEN", "bearer token of the control plane requests", func(c *Config) any { return &c.Control.Token }},
+	{"pid_file", "PID_FILE", "file to write the PID of the serving process to", func(c *Config) any { return &c.PIDFile }},
+}
+
+// Result is a loaded configuration
+type Result struct {
+	Config Config
+	// Sources maps the dotted keys of the settings that aren't defaults to where they come from
+	Sources map[string]string
+	// PrintConfig is set by --print-config
+	PrintConfig bool
+}
+
+// Load builds the configuration from the defaults, the config file, the variables
+// looked up with lookupEnv and the command line arguments, without the program name.
+// It reports every invalid setting at once, see Errors.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Result, error) {
+	var errs Errors
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configFile := flags.String("config", "", "YAML config file, overrides "+envConfigFile)
+	printConfig := flags.Bool("print-config", false, "print the effective config and exit")
+
+	// the flags are applied after the file, they are only collected while parsing
+	flagValues := make(map[string]string)
+	var flagOrder []string
+	for _, f := range fields {
+		key := f.key
+		flags.Func(key, f.usage+" ("+f.env+")", func(value string) error {
+			if _, ok := flagValues[key]; !ok {
+				flagOrder = append(flagOrder, key)
+			}
+			flagValues[key] = value
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, errors.New(Usage())
+		}
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+
+	res := &Result{Config: Default(), Sources: make(map[string]string), PrintConfig: *printConfig}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv(envConfigFile)
+	}
+
+	if path != "" {
+		keys, err := loadFile(path, &res.Config)
+
+		// mistyped values are reported with the rest, the file is read past them
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, e := range typeErr.Errors {
+				errs = append(errs, fmt.Errorf("file %s: %s", path, e))
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			res.Sources[key] = "file " + path
+		}
+	}
+
+	for _, f := range fields {
+		// empty variables are unset, e.g. placeholders in docker-compose.yml
+		value, ok := lookupEnv(f.env)
+		if !ok || value == "" {
+			continue
+		}
+
+		source := "variable " + f.env
+		res.Sources[f.key] = source
+		if err := set(f.value(&res.Config), value); err != nil {
+			errs = append(errs, &FieldError{Key: f.key, Source: source, Err: err})
+		}
+	}
+
+	for _, key := range flagOrder {
+		f := fieldByKey(key)
+
+		source := "flag --" + key
+		res.Sources[key] = source
+		if err := set(f.value(&res.Config), flagValues[key]); err != nil {
+			errs = append(errs, &FieldError{Key: key, Source: source, Err: err})
+		}
+	}
+
+	// a setting that failed to parse isn't validated again
+	if err := res.Config.Validate(res.Sources); err != nil {
+		for _, e := range err.(Errors) {
+			if !failed(errs, e.(*FieldError).Key) {
+				errs = append(errs, e)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return res, nil
+}
+
+// Usage describes the flags and the variables of the settings
+func Usage() string {
+	var b strings.Builder
+	b.WriteString("Usage: server [--config file] [--print-config] [--key value ...]\n\n")
+	b.WriteString("  --config\n\tYAML config file (" + envConfigFile + ")\n")
+	b.WriteString("  --print-config\n\tprint the effective config and exit\n")
+	for _, f := range fields {
+		fmt.Fprintf(&b, "  --%s\n\t%s (%s)\n", f.key, f.usage, f.env)
+	}
+
+	return b.String()
+}
+
+// loadFile reads the config file over the config and returns the keys it sets.
+// Unknown keys and mistyped values are returned as a *yaml.TypeError,
+// so that typos don't go unnoticed.
+func loadFile(path string, c *Config) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the config file")
+	}
+
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, errors.Wrapf(err, "invalid config file %s", path)
+	}
+
+	return keys("", tree), yaml.UnmarshalStrict(data, c)
+}
+
+// keys flattens the YAML tree into dotted keys
+func keys(prefix string, tree map[string]any) []string {
+	var list []string
+	for name, value := range tree {
+		key := prefix + name
+		if sub, ok := value.(map[any]any); ok {
+			nested := make(map[string]any, len(sub))
+			for k, v := range sub {
+				nested[fmt.Sprint(k)] = v
+			}
+			list = append(list, keys(key+".", nested)...)
+			continue
+		}
+		list = append(list, key)
+	}
+
+	return list
+}
+
+// set parses the value into the setting
+func set(setting any, value string) error {
+	switch p := setting.(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*p = n
+	case *float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 5s or 1m30s", value)
+		}
+		*p = d
+	case *[]string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+	default:
+		return fmt.Errorf("unsupported setting type %T", setting)
+	}
+
+	return nil
+}
+
+func fieldByKey(key string) field {
+	for _, f := range fields {
+		if f.key == key {
+			return f
+		}
+	}
+
+	panic("unknown config key " + key)
+}
+
+func failed(errs Errors, key string) bool {
+	for _, err := range errs {
+		if fe, ok := err.(*FieldError); ok && fe.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
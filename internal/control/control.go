@@ -0,0 +1,314 @@
+// Package control serves the admin control plane of a running server over HTTP.
+// Every request needs the bearer token and every action is written to the audit log.
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/internal/reputation"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+// maxDifficulty is the length of a hex SHA-256 digest
+const maxDifficulty = 64
+
+// maxBodySize bounds a request body
+const maxBodySize = 4 << 10
+
+// Server is the part of the TCP server the control plane operates
+type Server interface {
+	Live() server.LiveConfig
+	// UpdateLive changes the live config without losing a concurrent reload
+	UpdateLive(update func(config *server.LiveConfig)) server.LiveConfig
+	Connections() []server.ConnInfo
+	KillConnection(id uint64) bool
+}
+
+// Bans lists and lifts the bans of the clients
+type Bans interface {
+	Bans() []reputation.Ban
+	Lift(client string) bool
+}
+
+// Config holds what the control plane operates
+type Config struct {
+	// Token authenticates the requests, it must not be empty
+	Token string
+	// Server is served under the live config, changed by the control plane
+	Server Server
+	// Bans are the bans of the clients, nil if there are none
+	Bans Bans
+	// LoadQuotes loads the quotes file again
+	LoadQuotes func() (server.Quoter, error)
+	// Drain stops the server taking new connections, the process keeps running
+	Drain func()
+	// Log is the server log whose level is toggled
+	Log *log.Logger
+	// Audit receives a record of every request, regardless of the level of the server log
+	Audit log.FieldLogger
+}
+
+type controlPlane struct {
+	Config
+}
+
+// action serves a request and returns the status and the body of the response.
+// It adds the details of what it did to the audit record.
+type action func(r *http.Request, audit log.Fields) (int, any)
+
+type route struct {
+	method string
+	// name is the action in the audit log
+	name string
+	run  action
+}
+
+// NewHandler returns the handler of the control plane endpoints
+func NewHandler(config Config) http.Handler {
+	c := &controlPlane{Config: config}
+
+	mux := http.NewServeMux()
+	mux.Handle("/difficulty", c.routes(
+		route{http.MethodGet, "get_difficulty", c.getDifficulty},
+		route{http.MethodPut, "set_difficulty", c.setDifficulty},
+	))
+	mux.Handle("/connections", c.routes(
+		route{http.MethodGet, "list_connections", c.listConnections},
+	))
+	mux.Handle("/connections/", c.routes(
+		route{http.MethodDelete, "kill_connection", c.killConnection},
+	))
+	mux.Handle("/bans", c.routes(
+		route{http.MethodGet, "list_bans", c.listBans},
+	))
+	mux.Handle("/bans/", c.routes(
+		route{http.MethodDelete, "lift_ban", c.liftBan},
+	))
+	mux.Handle("/quotes/reload", c.routes(
+		route{http.MethodPost, "reload_quotes", c.reloadQuotes},
+	))
+	mux.Handle("/log-level", c.routes(
+		route{http.MethodGet, "get_log_level", c.getLogLevel},
+		route{http.MethodPut, "set_log_level", c.setLogLevel},
+	))
+	mux.Handle("/drain", c.routes(
+		route{http.MethodPost, "drain", c.drain},
+	))
+
+	return mux
+}
+
+// routes authenticates a request, runs the action of its method and writes the audit record
+func (c *controlPlane) routes(routes ...route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit := log.Fields{
+			"remote_addr": r.RemoteAddr,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+		}
+
+		if !c.authorized(r) {
+			c.Audit.WithFields(audit).Warn("admin request is unauthorized")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorBody("unauthorized"))
+			return
+		}
+
+		for _, route := range routes {
+			if route.method != r.Method {
+				continue
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+			status, body := route.run(r, audit)
+
+			audit["action"] = route.name
+			audit["status"] = status
+			entry := c.Audit.WithFields(audit)
+			if status >= http.StatusBadRequest {
+				entry.Warn("admin action failed")
+			} else {
+				entry.Info("admin action")
+			}
+
+			writeJSON(w, status, body)
+			return
+		}
+
+		allowed := make([]string, 0, len(routes))
+		for _, route := range routes {
+			allowed = append(allowed, route.method)
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody("method not allowed"))
+	})
+}
+
+func (c *controlPlane) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && c.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1
+}
+
+type difficulty struct {
+	Difficulty int `json:"difficulty"`
+}
+
+func (c *controlPlane) getDifficulty(r *http.Request, audit log.Fields) (int, any) {
+	return http.StatusOK, difficulty{Difficulty: c.Server.Live().PowDifficulty}
+}
+
+// setDifficulty changes the difficulty of new challenges until the config is reloaded
+func (c *controlPlane) setDifficulty(r *http.Request, audit log.Fields) (int, any) {
+	var body difficulty
+	if err := decodeJSON(r, &body); err != nil {
+		return http.StatusBadRequest, errorBody(err.Error())
+	}
+	if body.Difficulty < 1 || body.Difficulty > maxDifficulty {
+		return http.StatusBadRequest, errorBody(fmt.Sprintf("difficulty must be between 1 and %d", maxDifficulty))
+	}
+
+	c.Server.UpdateLive(func(live *server.LiveConfig) {
+		audit["from"], audit["to"] = live.PowDifficulty, body.Difficulty
+		live.PowDifficulty = body.Difficulty
+	})
+
+	return http.StatusOK, body
+}
+
+type connection struct {
+	ID         uint64    `json:"id"`
+	Client     string    `json:"client"`
+	RemoteAddr string    `json:"remote_addr"`
+	Listener   string    `json:"listener"`
+	Accepted   time.Time `json:"accepted"`
+}
+
+func (c *controlPlane) listConnections(r *http.Request, audit log.Fields) (int, any) {
+	list := make([]connection, 0)
+	for _, info := range c.Server.Connections() {
+		conn := connection{ID: info.ID, Client: info.Client, Listener: info.Listener, Accepted: info.Accepted}
+		if info.RemoteAddr != nil {
+			conn.RemoteAddr = info.RemoteAddr.String()
+		}
+		list = append(list, conn)
+	}
+
+	return http.StatusOK, list
+}
+
+func (c *controlPlane) killConnection(r *http.Request, audit log.Fields) (int, any) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/connections/"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, errorBody("invalid connection ID")
+	}
+	audit["conn_id"] = id
+
+	if !c.Server.KillConnection(id) {
+		return http.StatusNotFound, errorBody("no such connection")
+	}
+
+	return http.StatusOK, map[string]uint64{"killed": id}
+}
+
+type ban struct {
+	Client string    `json:"client"`
+	Until  time.Time `json:"until"`
+}
+
+func (c *controlPlane) listBans(r *http.Request, audit log.Fields) (int, any) {
+	list := make([]ban, 0)
+	if c.Bans != nil {
+		for _, b := range c.Bans.Bans() {
+			list = append(list, ban{Client: b.Client, Until: b.Until})
+		}
+	}
+
+	return http.StatusOK, list
+}
+
+func (c *controlPlane) liftBan(r *http.Request, audit log.Fields) (int, any) {
+	client := strings.TrimPrefix(r.URL.Path, "/bans/")
+	audit["client"] = client
+
+	if c.Bans == nil || !c.Bans.Lift(client) {
+		return http.StatusNotFound, errorBody("client is not banned")
+	}
+
+	return http.StatusOK, map[string]string{"lifted": client}
+}
+
+// reloadQuotes swaps in the quotes file, the other settings stay as they are
+func (c *controlPlane) reloadQuotes(r *http.Request, audit log.Fields) (int, any) {
+	quotes, err := c.LoadQuotes()
+	if err != nil {
+		audit["error"] = err.Error()
+		return http.StatusInternalServerError, errorBody(err.Error())
+	}
+
+	c.Server.UpdateLive(func(live *server.LiveConfig) {
+		live.Quotes = quotes
+	})
+
+	return http.StatusOK, map[string]int{"categories": len(quotes.Categories())}
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (c *controlPlane) getLogLevel(r *http.Request, audit log.Fields) (int, any) {
+	return http.StatusOK, logLevel{Level: c.Log.GetLevel().String()}
+}
+
+// setLogLevel changes the level of the server log until the config is reloaded
+func (c *controlPlane) setLogLevel(r *http.Request, audit log.Fields) (int, any) {
+	var body logLevel
+	if err := decodeJSON(r, &body); err != nil {
+		return http.StatusBadRequest, errorBody(err.Error())
+	}
+
+	level, err := log.ParseLevel(body.Level)
+	if err != nil {
+		return http.StatusBadRequest, errorBody(err.Error())
+	}
+
+	audit["from"], audit["to"] = c.Log.GetLevel().String(), level.String()
+	c.Log.SetLevel(level)
+
+	return http.StatusOK, logLevel{Level: level.String()}
+}
+
+func (c *controlPlane) drain(r *http.Request, audit log.Fields) (int, any) {
+	c.Drain()
+
+	return http.StatusAccepted, map[string]string{"status": "draining"}
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return errors.New("invalid request body: " + err.Error())
+	}
+
+	return nil
+}
+
+func errorBody(message string) map[string]string {
+	return map[string]string{"error": message}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,245 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/reputation"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+const token = "secret-token"
+
+type fakeServer struct {
+	live        server.LiveConfig
+	connections []server.ConnInfo
+	killed      []uint64
+}
+
+func (s *fakeServer) Live() server.LiveConfig        { return s.live }
+func (s *fakeServer) Connections() []server.ConnInfo { return s.connections }
+func (s *fakeServer) UpdateLive(update func(*server.LiveConfig)) server.LiveConfig {
+	update(&s.live)
+	return s.live
+}
+func (s *fakeServer) KillConnection(id uint64) bool {
+	for _, info := range s.connections {
+		if info.ID == id {
+			s.killed = append(s.killed, id)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeQuoter struct {
+	categories []string
+}
+
+func (q fakeQuoter) GetRandomQuote(quotes.Filter) (quotes.Quote, error) { return quotes.Quote{}, nil }
+func (q fakeQuoter) Categories() []string                               { return q.categories }
+
+type fixture struct {
+	handler http.Handler
+	server  *fakeServer
+	bans    *reputation.Tracker
+	log     *log.Logger
+	audit   *test.Hook
+	drained bool
+	reload  error
+}
+
+func newFixture() *fixture {
+	f := &fixture{
+		server: &fakeServer{live: server.LiveConfig{PowDifficulty: 4}},
+		bans:   reputation.New(reputation.Config{BanThreshold: 1, BanDuration: time.Hour}),
+		log:    log.New(),
+	}
+
+	audit, hook := test.NewNullLogger()
+	f.audit = hook
+
+	f.handler = NewHandler(Config{
+		Token:  token,
+		Server: f.server,
+		Bans:   f.bans,
+		LoadQuotes: func() (server.Quoter, error) {
+			if f.reload != nil {
+				return nil, f.reload
+			}
+			return fakeQuoter{categories: []string{"life", "wisdom"}}, nil
+		},
+		Drain: func() { f.drained = true },
+		Log:   f.log,
+		Audit: audit,
+	})
+
+	return f
+}
+
+func (f *fixture) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+	return body
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	f := newFixture()
+
+	for _, header := range []string{"", "Bearer wrong", token, "Basic " + token} {
+		req := httptest.NewRequest(http.MethodPost, "/drain", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		rec := httptest.NewRecorder()
+		f.handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, header)
+		assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+	}
+
+	assert.False(t, f.drained)
+	assert.Len(t, f.audit.AllEntries(), 4)
+	assert.Equal(t, log.WarnLevel, f.audit.LastEntry().Level)
+	assert.Equal(t, "/drain", f.audit.LastEntry().Data["path"])
+}
+
+func TestHandler_Difficulty(t *testing.T) {
+	f := newFixture()
+
+	rec := f.do(http.MethodGet, "/difficulty", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 4.0, decode(t, rec)["difficulty"])
+
+	rec = f.do(http.MethodPut, "/difficulty", `{"difficulty": 6}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 6, f.server.live.PowDifficulty)
+
+	entry := f.audit.LastEntry()
+	assert.Equal(t, "admin action", entry.Message)
+	assert.Equal(t, "set_difficulty", entry.Data["action"])
+	assert.Equal(t, 4, entry.Data["from"])
+	assert.Equal(t, 6, entry.Data["to"])
+
+	for _, body := range []string{`{"difficulty": 0}`, `{"difficulty": 65}`, `{"level": 3}`, `five`} {
+		rec = f.do(http.MethodPut, "/difficulty", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	assert.Equal(t, 6, f.server.live.PowDifficulty)
+	assert.Equal(t, "admin action failed", f.audit.LastEntry().Message)
+
+	rec = f.do(http.MethodPost, "/difficulty", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, PUT", rec.Header().Get("Allow"))
+}
+
+func TestHandler_Connections(t *testing.T) {
+	f := newFixture()
+	accepted := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.server.connections = []server.ConnInfo{{
+		ID:         3,
+		Client:     "10.0.0.1",
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+		Listener:   "tcp",
+		Accepted:   accepted,
+	}}
+
+	rec := f.do(http.MethodGet, "/connections", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"id":3,"client":"10.0.0.1","remote_addr":"10.0.0.1:5000","listener":"tcp","accepted":"2026-01-01T00:00:00Z"}]`, rec.Body.String())
+
+	rec = f.do(http.MethodDelete, "/connections/3", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []uint64{3}, f.server.killed)
+	assert.Equal(t, uint64(3), f.audit.LastEntry().Data["conn_id"])
+
+	assert.Equal(t, http.StatusNotFound, f.do(http.MethodDelete, "/connections/4", "").Code)
+	assert.Equal(t, http.StatusBadRequest, f.do(http.MethodDelete, "/connections/x", "").Code)
+}
+
+func TestHandler_Bans(t *testing.T) {
+	f := newFixture()
+	f.bans.RecordFailure("10.0.0.1")
+
+	rec := f.do(http.MethodGet, "/bans", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var bans []map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bans))
+	if assert.Len(t, bans, 1) {
+		assert.Equal(t, "10.0.0.1", bans[0]["client"])
+	}
+
+	rec = f.do(http.MethodDelete, "/bans/10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, f.bans.IsBanned("10.0.0.1"))
+	assert.Equal(t, "10.0.0.1", f.audit.LastEntry().Data["client"])
+
+	assert.Equal(t, http.StatusNotFound, f.do(http.MethodDelete, "/bans/10.0.0.1", "").Code)
+	assert.Equal(t, "[]\n", f.do(http.MethodGet, "/bans", "").Body.String())
+}
+
+func TestHandler_ReloadQuotes(t *testing.T) {
+	f := newFixture()
+
+	rec := f.do(http.MethodPost, "/quotes/reload", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2.0, decode(t, rec)["categories"])
+	assert.NotNil(t, f.server.live.Quotes)
+	assert.Equal(t, 4, f.server.live.PowDifficulty)
+
+	f.reload = errors.New("quotes.yml: no such file")
+	rec = f.do(http.MethodPost, "/quotes/reload", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "quotes.yml: no such file", f.audit.LastEntry().Data["error"])
+}
+
+func TestHandler_LogLevel(t *testing.T) {
+	f := newFixture()
+	f.log.SetLevel(log.InfoLevel)
+
+	rec := f.do(http.MethodPut, "/log-level", `{"level": "error"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, log.ErrorLevel, f.log.GetLevel())
+
+	// the audit log doesn't follow the level of the server log
+	entry := f.audit.LastEntry()
+	assert.Equal(t, "set_log_level", entry.Data["action"])
+	assert.Equal(t, "info", entry.Data["from"])
+
+	rec = f.do(http.MethodGet, "/log-level", "")
+	assert.Equal(t, "error", decode(t, rec)["level"])
+
+	assert.Equal(t, http.StatusBadRequest, f.do(http.MethodPut, "/log-level", `{"level": "loud"}`).Code)
+}
+
+func TestHandler_Drain(t *testing.T) {
+	f := newFixture()
+
+	rec := f.do(http.MethodPost, "/drain", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.True(t, f.drained)
+	assert.Equal(t, "drain", f.audit.LastEntry().Data["action"])
+}
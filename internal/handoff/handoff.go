@@ -0,0 +1,255 @@
+// Package handoff passes listening sockets between processes: to a new process
+// of the server on a zero-downtime restart and from systemd on socket activation.
+//
+// Sockets are passed the systemd way, as file descriptors starting at 3 with
+// LISTEN_FDS holding their number and LISTEN_FDNAMES their colon-separated names.
+package handoff
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// listenFDsStart is the first passed file descriptor, after stdin, stdout and stderr
+	listenFDsStart = 3
+
+	envListenFDs     = "LISTEN_FDS"
+	envListenPID     = "LISTEN_PID"
+	envListenFDNames = "LISTEN_FDNAMES"
+	// envReadyFD is the pipe a restarted process reports its readiness to
+	envReadyFD = "READY_FD"
+
+	readyMessage = "READY"
+)
+
+// Socket is a named socket to pass to another process
+type Socket struct {
+	Name string
+	File *os.File
+}
+
+// Inherited holds the sockets passed to the process
+type Inherited struct {
+	files map[string]*os.File
+	names []string
+}
+
+// Inherit takes the sockets passed to the process and clears the variables that describe them.
+// Sockets meant for another process, according to LISTEN_PID, are ignored.
+func Inherit() (*Inherited, error) {
+	in := &Inherited{files: make(map[string]*os.File)}
+
+	count := os.Getenv(envListenFDs)
+	if count == "" {
+		return in, nil
+	}
+
+	pid := os.Getenv(envListenPID)
+	names := os.Getenv(envListenFDNames)
+
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDNames)
+
+	// a restarted process can't know its PID in advance, systemd always sets it
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return in, nil
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s %q", envListenFDs, count)
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		// unnamed sockets are told apart by their descriptors
+		name := fmt.Sprintf("fd%d", fd)
+		if i < len(fdNames) && fdNames[i] != "" && fdNames[i] != "unknown" {
+			name = fdNames[i]
+		}
+
+		if _, ok := in.files[name]; ok {
+			return nil, fmt.Errorf("socket %q is passed twice", name)
+		}
+
+		in.files[name] = os.NewFile(uintptr(fd), name)
+		in.names = append(in.names, name)
+	}
+
+	return in, nil
+}
+
+// Listener takes the stream socket with the name, false if there is no such socket
+func (in *Inherited) Listener(name string) (net.Listener, bool, error) {
+	file, ok := in.take(name)
+	if !ok {
+		return nil, false, nil
+	}
+	defer file.Close()
+
+	l, err := net.FileListener(file)
+	if err != nil {
+		return nil, true, errors.Wrapf(err, "socket %q is not a listener", name)
+	}
+
+	return l, true, nil
+}
+
+// PacketConn takes the datagram socket with the name, false if there is no such socket
+func (in *Inherited) PacketConn(name string) (net.PacketConn, bool, error) {
+	file, ok := in.take(name)
+	if !ok {
+		return nil, false, nil
+	}
+	defer file.Close()
+
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, true, errors.Wrapf(err, "socket %q is not a datagram socket", name)
+	}
+
+	return conn, true, nil
+}
+
+// Names returns the names of the sockets that haven't been taken yet, in the order they were passed
+func (in *Inherited) Names() []string {
+	var names []string
+	for _, name := range in.names {
+		if _, ok := in.files[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func (in *Inherited) take(name string) (*os.File, bool) {
+	file, ok := in.files[name]
+	delete(in.files, name)
+
+	return file, ok
+}
+
+// Restart starts a new process of the running binary with the same arguments,
+// passes the sockets to it and waits until it reports readiness with Ready.
+// The process is killed if it doesn't get ready before the context is done.
+// Restart returns the PID of the new process.
+func Restart(ctx context.Context, sockets []Socket) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find the executable")
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create the readiness pipe")
+	}
+	defer readyReader.Close()
+
+	names := make([]string, 0, len(sockets))
+	files := make([]*os.File, 0, len(sockets)+1)
+	for _, socket := range sockets {
+		names = append(names, socket.Name)
+		files = append(files, socket.File)
+	}
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(childEnv(),
+		envListenFDs+"="+strconv.Itoa(len(sockets)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(sockets)),
+	)
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start the new process")
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		// the pipe is closed without the message if the process exits
+		line, err := bufio.NewReader(readyReader).ReadString('\n')
+		if err == nil && strings.TrimSpace(line) != readyMessage {
+			err = fmt.Errorf("unexpected readiness message %q", line)
+		}
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return 0, errors.Wrap(err, "the new process failed to get ready")
+		}
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, errors.Wrap(ctx.Err(), "the new process failed to get ready")
+	}
+
+	// the new process outlives this one, it's not waited for
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	return pid, nil
+}
+
+// Ready tells the process that started this one with Restart that it serves the passed sockets,
+// it does nothing for a process started otherwise
+func Ready() error {
+	value := os.Getenv(envReadyFD)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(envReadyFD)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", envReadyFD, value)
+	}
+
+	pipe := os.NewFile(uintptr(fd), "ready")
+	defer pipe.Close()
+
+	_, err = fmt.Fprintln(pipe, readyMessage)
+	return err
+}
+
+// childEnv returns the environment without the variables describing the sockets of this process
+func childEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch key, _, _ := strings.Cut(kv, "="); key {
+		case envListenFDs, envListenPID, envListenFDNames, envReadyFD:
+		default:
+			env = append(env, kv)
+		}
+	}
+
+	return env
+}
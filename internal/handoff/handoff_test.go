@@ -0,0 +1,114 @@
+package handoff
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const envHelper = "HANDOFF_TEST_HELPER"
+
+// TestHelperProcess is the new process started by TestRestart
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(envHelper) == "" {
+		t.Skip("started by TestRestart only")
+	}
+
+	inherited, err := Inherit()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	l, ok, err := inherited.Listener("quotes")
+	if !ok || err != nil {
+		os.Exit(1)
+	}
+
+	if err := Ready(); err != nil {
+		os.Exit(1)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	conn.Write([]byte("served by the new process\n"))
+	conn.Close()
+	os.Exit(0)
+}
+
+func TestRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer l.Close()
+
+	file, err := l.(*net.TCPListener).File()
+	assert.NoError(t, err)
+
+	defer file.Close()
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHelperProcess$"}
+	defer func() { os.Args = args }()
+
+	t.Setenv(envHelper, "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pid, err := Restart(ctx, []Socket{{Name: "quotes", File: file}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, os.Getpid(), pid)
+
+	// this process doesn't accept, the connection is served by the new one
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "served by the new process\n", line)
+}
+
+func TestRestart_NotReady(t *testing.T) {
+	args := os.Args
+	// the process fails to start serving without the socket
+	os.Args = []string{args[0], "-test.run=^TestHelperProcess$"}
+	defer func() { os.Args = args }()
+
+	t.Setenv(envHelper, "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := Restart(ctx, nil)
+	assert.Error(t, err)
+}
+
+func TestInherit(t *testing.T) {
+	// sockets passed to another process are left alone
+	t.Setenv(envListenFDs, "1")
+	t.Setenv(envListenPID, "1")
+	t.Setenv(envListenFDNames, "quotes")
+
+	inherited, err := Inherit()
+	assert.NoError(t, err)
+	assert.Empty(t, inherited.Names())
+	assert.Empty(t, os.Getenv(envListenFDs))
+
+	_, ok, err := inherited.Listener("quotes")
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	t.Setenv(envListenFDs, "many")
+	_, err = Inherit()
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LatencyBuckets are the upper bounds in seconds of latencies from a fraction of
+// a millisecond, like writing a response, to minutes, like solving a hard challenge
+var LatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+	0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300,
+}
+
+// Histogram counts observations in buckets by their label values
+type Histogram struct {
+	help    string
+	buckets []float64
+	labels  []string
+
+	mutex   sync.Mutex
+	samples map[string]*histogramSample
+}
+
+type histogramSample struct {
+	labelValues []string
+	// counts are per bucket, the last one counts the observations above every bound
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram that isn't exported, e.g. to compute quantiles in process.
+// The bucket bounds must be sorted.
+func NewHistogram(buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		labels:  labels,
+		samples: make(map[string]*histogramSample),
+	}
+}
+
+// Histogram registers a histogram with the bucket bounds and the label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := NewHistogram(buckets, labels...)
+	h.help = help
+	r.register(name, h)
+
+	return h
+}
+
+// Observe counts the value in the sample with the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s := h.sample(labelValues)
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.count++
+	s.sum += value
+}
+
+// Quantile estimates the q-quantile of the sample with the label values by interpolating
+// within its bucket, like histogram_quantile does. It's NaN without observations.
+func (h *Histogram) Quantile(q float64, labelValues ...string) float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.samples[strings.Join(labelValues, labelSeparator)]
+	if !ok || s.count == 0 {
+		return math.NaN()
+	}
+
+	rank := q * float64(s.count)
+	var cumulative uint64
+	for i, n := range s.counts {
+		if float64(cumulative+n) < rank || n == 0 {
+			cumulative += n
+			continue
+		}
+
+		// observations above the last bound are reported at the bound
+		if i == len(h.buckets) {
+			return h.buckets[len(h.buckets)-1]
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = h.buckets[i-1]
+		}
+
+		return lower + (h.buckets[i]-lower)*(rank-float64(cumulative))/float64(n)
+	}
+
+	return h.buckets[len(h.buckets)-1]
+}
+
+// Count returns the number of observations of the sample with the label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.samples[strings.Join(labelValues, labelSeparator)]
+	if !ok {
+		return 0
+	}
+
+	return s.count
+}
+
+// sample returns the sample with the label values, the mutex must be held
+func (h *Histogram) sample(labelValues []string) *histogramSample {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(h.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, labelSeparator)
+	s, ok := h.samples[key]
+	if !ok {
+		s = &histogramSample{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+		}
+		h.samples[key] = s
+	}
+
+	return s
+}
+
+// sortedSamples returns the samples ordered by their label values, the mutex must be held
+func (h *Histogram) sortedSamples() []*histogramSample {
+	keys := make([]string, 0, len(h.samples))
+	for key := range h.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	samples := make([]*histogramSample, 0, len(keys))
+	for _, key := range keys {
+		samples = append(samples, h.samples[key])
+	}
+
+	return samples
+}
+
+func (h *Histogram) write(w io.Writer, name string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := writeHeader(w, name, h.help, "histogram"); err != nil {
+		return err
+	}
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+
+	for _, s := range h.sortedSamples() {
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+
+			labelValues := append(append([]string(nil), s.labelValues...), formatValue(le))
+			if err := writeSample(w, name+"_bucket", bucketLabels, labelValues, float64(cumulative)); err != nil {
+				return err
+			}
+		}
+
+		if err := writeSample(w, name+"_sum", h.labels, s.labelValues, s.sum); err != nil {
+			return err
+		}
+		if err := writeSample(w, name+"_count", h.labels, s.labelValues, float64(s.count)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Quantiles registers a gauge of the quantiles of the histogram, estimated at every scrape,
+// for dashboards that can't compute them from the buckets
+func (r *Registry) Quantiles(name, help string, h *Histogram, quantiles ...float64) {
+	r.register(name, quantileGauge{help: help, histogram: h, quantiles: quantiles})
+}
+
+type quantileGauge struct {
+	help      string
+	histogram *Histogram
+	quantiles []float64
+}
+
+func (g quantileGauge) write(w io.Writer, name string) error {
+	if err := writeHeader(w, name, g.help, "gauge"); err != nil {
+		return err
+	}
+
+	h := g.histogram
+	h.mutex.Lock()
+	samples := h.sortedSamples()
+	h.mutex.Unlock()
+
+	labels := append(append([]string(nil), h.labels...), "quantile")
+
+	for _, s := range samples {
+		for _, q := range g.quantiles {
+			labelValues := append(append([]string(nil), s.labelValues...), strconv.FormatFloat(q, 'g', -1, 64))
+			if err := writeSample(w, name, labels, labelValues, h.Quantile(q, s.labelValues...)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
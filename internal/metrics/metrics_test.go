@@ -0,0 +1,134 @@
+package metrics_test
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/metrics"
+	"github.com/zhashkevych/quotes-server/internal/server"
+)
+
+var _ server.MetricsSink = (*metrics.ServerMetrics)(nil)
+
+func TestRegistry_Write(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.Counter("requests_total", "Requests by path.", "path", "code")
+	inFlight := r.Gauge("in_flight", "Requests in flight.")
+	r.Counter("errors_total", "Errors.\nEver.")
+	r.GaugeFunc("temperature", "Current temperature.", func() float64 { return 21.5 })
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc(`/"quoted"`, "500")
+	inFlight.Add(3)
+	inFlight.Add(-1)
+
+	var b strings.Builder
+	assert.NoError(t, r.Write(&b))
+
+	assert.Equal(t, `# HELP errors_total Errors.\nEver.
+# TYPE errors_total counter
+errors_total 0
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 2
+# HELP requests_total Requests by path.
+# TYPE requests_total counter
+requests_total{path="/\"quoted\"",code="500"} 1
+requests_total{path="/a",code="200"} 2
+requests_total{path="/b",code="200"} 1
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 21.5
+`, b.String())
+}
+
+func TestRegistry_RegisterTwice(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("requests_total", "Requests.")
+
+	assert.Panics(t, func() { r.Gauge("requests_total", "Requests.") })
+}
+
+func TestServerMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := metrics.NewServerMetrics(r)
+	metrics.RegisterDifficulty(r, func() int { return 5 })
+
+	m.ConnectionAccepted("tcp")
+	m.ConnectionAccepted("tcp")
+	m.ConnectionClosed("tcp")
+	m.ChallengeIssued("tcp")
+	m.SolutionRejected("tcp", "wrong")
+	m.SolutionAccepted("tcp")
+	m.RequestHandled("tcp", time.Millisecond)
+	m.QuoteServed("tcp")
+	m.Timeout("solve")
+	m.RateLimited("http")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, metrics.ContentType, rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`quotes_connections_accepted_total{listener="tcp"} 2`,
+		`quotes_connections_active{listener="tcp"} 1`,
+		`quotes_challenges_issued_total{listener="tcp"} 1`,
+		`quotes_solutions_accepted_total{listener="tcp"} 1`,
+		`quotes_solutions_rejected_total{listener="tcp",reason="wrong"} 1`,
+		`quotes_served_total{listener="tcp"} 1`,
+		`quotes_timeouts_total{phase="solve"} 1`,
+		`quotes_rate_limited_total{listener="http"} 1`,
+		`quotes_pow_difficulty 5`,
+		`quotes_proxy_rejected_total 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	h := metrics.NewHistogram([]float64{1, 2, 4}, "phase")
+
+	assert.True(t, math.IsNaN(h.Quantile(0.5, "solve")))
+
+	// a quarter of the observations in every bucket, the last quarter above the bounds
+	for _, value := range []float64{0.5, 1.5, 3, 10} {
+		h.Observe(value, "solve")
+	}
+
+	assert.Equal(t, uint64(4), h.Count("solve"))
+	assert.InDelta(t, 1, h.Quantile(0.25, "solve"), 1e-9)
+	assert.InDelta(t, 2, h.Quantile(0.5, "solve"), 1e-9)
+	assert.InDelta(t, 3, h.Quantile(0.625, "solve"), 1e-9)
+	assert.InDelta(t, 4, h.Quantile(0.99, "solve"), 1e-9)
+}
+
+func TestRegistry_Histogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "phase")
+	r.Quantiles("latency_quantile_seconds", "Latency quantiles.", h, 0.5)
+
+	h.Observe(0.05, "verify")
+	h.Observe(0.5, "verify")
+
+	var b strings.Builder
+	assert.NoError(t, r.Write(&b))
+
+	assert.Equal(t, `# HELP latency_quantile_seconds Latency quantiles.
+# TYPE latency_quantile_seconds gauge
+latency_quantile_seconds{phase="verify",quantile="0.5"} 0.1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{phase="verify",le="0.1"} 1
+latency_seconds_bucket{phase="verify",le="1"} 2
+latency_seconds_bucket{phase="verify",le="+Inf"} 2
+latency_seconds_sum{phase="verify"} 0.55
+latency_seconds_count{phase="verify"} 2
+`, b.String())
+}
@@ -0,0 +1,277 @@
+// Package metrics exports measurements in the Prometheus text exposition format
+// without depending on the Prometheus client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelSeparator joins the label values of a sample into its key, it can't appear in valid UTF-8
+const labelSeparator = "\xff"
+
+// Registry holds the metrics of a process
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]family
+}
+
+// family is a metric with all of its samples
+type family interface {
+	write(w io.Writer, name string) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register adds the family, a name can be registered once
+func (r *Registry) register(name string, f family) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %s is registered twice", name))
+	}
+	r.families[name] = f
+}
+
+// Counter registers a counter with the label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(help, "counter", labels)}
+	r.register(name, c.vec)
+
+	return c
+}
+
+// Gauge registers a gauge with the label names
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(help, "gauge", labels)}
+	r.register(name, g.vec)
+
+	return g
+}
+
+// GaugeFunc registers a gauge without labels that is read at every scrape
+func (r *Registry) GaugeFunc(name, help string, value func() float64) {
+	r.register(name, gaugeFunc{help: help, value: value})
+}
+
+// Write writes the metrics sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.families))
+	families := make(map[string]family, len(r.families))
+	for name, f := range r.families {
+		names = append(names, name)
+		families[name] = f
+	}
+	r.mutex.Unlock()
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := families[name].write(w, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler serves the metrics to scrapers
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// Counter only goes up
+type Counter struct {
+	vec *vec
+}
+
+// Inc adds one to the sample with the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a non-negative value to the sample with the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic("counter can't decrease")
+	}
+	c.vec.add(value, labelValues)
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	vec *vec
+}
+
+// Set sets the sample with the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.vec.set(value, labelValues)
+}
+
+// Add adds to the sample with the label values, the value may be negative
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.vec.add(value, labelValues)
+}
+
+// vec holds the samples of a counter or a gauge by their label values
+type vec struct {
+	help   string
+	kind   string
+	labels []string
+
+	mutex   sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newVec(help, kind string, labels []string) *vec {
+	return &vec{help: help, kind: kind, labels: labels, samples: make(map[string]*sample)}
+}
+
+// sample returns the sample with the label values, vec.mutex must be held
+func (v *vec) sample(labelValues []string) *sample {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, labelSeparator)
+	s, ok := v.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.samples[key] = s
+	}
+
+	return s
+}
+
+func (v *vec) add(value float64, labelValues []string) {
+	v.mutex.Lock()
+	v.sample(labelValues).value += value
+	v.mutex.Unlock()
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	v.mutex.Lock()
+	v.sample(labelValues).value = value
+	v.mutex.Unlock()
+}
+
+func (v *vec) write(w io.Writer, name string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if err := writeHeader(w, name, v.help, v.kind); err != nil {
+		return err
+	}
+
+	// a metric without labels is zero until it's measured
+	if len(v.labels) == 0 && len(v.samples) == 0 {
+		return writeSample(w, name, nil, nil, 0)
+	}
+
+	keys := make([]string, 0, len(v.samples))
+	for key := range v.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.samples[key]
+		if err := writeSample(w, name, v.labels, s.labelValues, s.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type gaugeFunc struct {
+	help  string
+	value func() float64
+}
+
+func (g gaugeFunc) write(w io.Writer, name string) error {
+	if err := writeHeader(w, name, g.help, "gauge"); err != nil {
+		return err
+	}
+
+	return writeSample(w, name, nil, nil, g.value())
+}
+
+func writeHeader(w io.Writer, name, help, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+	return err
+}
+
+// writeSample writes a sample line: name{label="value",...} value
+func writeSample(w io.Writer, name string, labels, labelValues []string, value float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabelValue(labelValues[i]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
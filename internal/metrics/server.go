@@ -0,0 +1,114 @@
+package metrics
+
+import "time"
+
+// ServerMetrics exports the measurements of the quotes server, it implements server.MetricsSink
+type ServerMetrics struct {
+	connectionsAccepted *Counter
+	connectionsActive   *Gauge
+	challengesIssued    *Counter
+	solutionsAccepted   *Counter
+	solutionsRejected   *Counter
+	timeouts            *Counter
+	requestsHandled     *Counter
+	quotesServed        *Counter
+	requestsInProgress  *Gauge
+	slowClients         *Counter
+	proxyRejected       *Counter
+	queueDepth          *Gauge
+	dropped             *Counter
+	rateLimited         *Counter
+	phaseDurations      *Histogram
+}
+
+// NewServerMetrics registers the metrics of the quotes server
+func NewServerMetrics(r *Registry) *ServerMetrics {
+	phaseDurations := r.Histogram("quotes_phase_duration_seconds", "Durations of the phases of an exchange: challenge_send, solve, verify and quote_send.", LatencyBuckets, "phase")
+	r.Quantiles("quotes_phase_duration_quantile_seconds", "Estimated p50, p90 and p99 of the phase durations.", phaseDurations, 0.5, 0.9, 0.99)
+
+	return &ServerMetrics{
+		connectionsAccepted: r.Counter("quotes_connections_accepted_total", "Connections accepted by listener.", "listener"),
+		connectionsActive:   r.Gauge("quotes_connections_active", "Connections being served by listener.", "listener"),
+		challengesIssued:    r.Counter("quotes_challenges_issued_total", "Proof-of-work challenges issued by listener.", "listener"),
+		solutionsAccepted:   r.Counter("quotes_solutions_accepted_total", "Correct solutions by listener.", "listener"),
+		solutionsRejected:   r.Counter("quotes_solutions_rejected_total", "Rejected solutions by listener and reason.", "listener", "reason"),
+		timeouts:            r.Counter("quotes_timeouts_total", "Deadlines missed by clients by phase.", "phase"),
+		requestsHandled:     r.Counter("quotes_requests_handled_total", "Requests served with quotes by listener.", "listener"),
+		quotesServed:        r.Counter("quotes_served_total", "Quotes sent by listener.", "listener"),
+		requestsInProgress:  r.Gauge("quotes_requests_in_progress", "Connections in the middle of a request."),
+		slowClients:         r.Counter("quotes_slow_clients_total", "Connections closed by the slow client policy by reason.", "reason"),
+		proxyRejected:       r.Counter("quotes_proxy_rejected_total", "Connections without a valid PROXY protocol header."),
+		queueDepth:          r.Gauge("quotes_pool_queue_depth", "Connections waiting for a worker."),
+		dropped:             r.Counter("quotes_pool_dropped_total", "Connections turned away because the worker pool was full by listener.", "listener"),
+		rateLimited:         r.Counter("quotes_rate_limited_total", "Requests of banned clients by listener.", "listener"),
+		phaseDurations:      phaseDurations,
+	}
+}
+
+func (m *ServerMetrics) ConnectionAccepted(listener string) {
+	m.connectionsAccepted.Inc(listener)
+	m.connectionsActive.Add(1, listener)
+}
+
+func (m *ServerMetrics) ConnectionClosed(listener string) {
+	m.connectionsActive.Add(-1, listener)
+}
+
+func (m *ServerMetrics) ChallengeIssued(listener string) {
+	m.challengesIssued.Inc(listener)
+}
+
+func (m *ServerMetrics) SolutionAccepted(listener string) {
+	m.solutionsAccepted.Inc(listener)
+}
+
+func (m *ServerMetrics) SolutionRejected(listener, reason string) {
+	m.solutionsRejected.Inc(listener, reason)
+}
+
+func (m *ServerMetrics) Timeout(phase string) {
+	m.timeouts.Inc(phase)
+}
+
+func (m *ServerMetrics) RequestHandled(listener string, responseTime time.Duration) {
+	m.requestsHandled.Inc(listener)
+}
+
+func (m *ServerMetrics) QuoteServed(listener string) {
+	m.quotesServed.Inc(listener)
+}
+
+func (m *ServerMetrics) RequestsInProgress(delta int) {
+	m.requestsInProgress.Add(float64(delta))
+}
+
+func (m *ServerMetrics) SlowClient(reason string) {
+	m.slowClients.Inc(reason)
+}
+
+func (m *ServerMetrics) ProxyRejected() {
+	m.proxyRejected.Inc()
+}
+
+func (m *ServerMetrics) QueueDepth(depth int) {
+	m.queueDepth.Set(float64(depth))
+}
+
+func (m *ServerMetrics) ConnectionDropped(listener string) {
+	m.dropped.Inc(listener)
+}
+
+func (m *ServerMetrics) RateLimited(listener string) {
+	m.rateLimited.Inc(listener)
+}
+
+func (m *ServerMetrics) PhaseDuration(phase string, duration time.Duration) {
+	m.phaseDurations.Observe(duration.Seconds(), phase)
+}
+
+// RegisterDifficulty registers the gauge of the current difficulty, read from the server at every scrape
+func RegisterDifficulty(r *Registry, difficulty func() int) {
+	r.GaugeFunc("quotes_pow_difficulty", "Difficulty of new challenges.", func() float64 {
+		return float64(difficulty())
+	})
+}
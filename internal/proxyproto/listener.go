@@ -0,0 +1,202 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Conn is a connection with the addresses recovered from its PROXY protocol header
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the address of the client behind the balancer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr returns the address the client connected to at the balancer
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// Listener accepts connections whose PROXY protocol headers are read before they're returned.
+// Connections from trusted sources must start with a header, others are returned unchanged,
+// so that a client can't spoof its address by sending a header of its own.
+// Headers are read in the background, a slow or broken connection doesn't hold up the others.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+
+	// OnReject, if set, is called for connections closed for a missing or invalid header
+	OnReject func(addr net.Addr, err error)
+	// MaxHandshakes, if positive, bounds the connections whose headers are read or that wait
+	// to be returned at once. The listener stops accepting while that many are in flight.
+	MaxHandshakes int
+
+	startOnce  sync.Once
+	closeOnce  sync.Once
+	handshakes chan struct{}
+	conns      chan net.Conn
+	errs       chan error
+	done       chan struct{}
+}
+
+// NewListener reads the headers of connections from the trusted networks within the timeout
+func NewListener(l net.Listener, trusted []*net.IPNet, timeout time.Duration) *Listener {
+	return &Listener{
+		Listener: l,
+		trusted:  trusted,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() {
+		if l.MaxHandshakes > 0 {
+			l.handshakes = make(chan struct{}, l.MaxHandshakes)
+		}
+		go l.acceptLoop()
+	})
+
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.Listener.Close()
+	})
+
+	return err
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		if !l.acquire() {
+			return
+		}
+
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.release()
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		go l.handshake(conn)
+	}
+}
+
+// acquire takes a place for a handshake, waiting for one if MaxHandshakes are in flight.
+// It returns false once the listener is closed.
+func (l *Listener) acquire() bool {
+	if l.handshakes == nil {
+		return true
+	}
+
+	select {
+	case l.handshakes <- struct{}{}:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *Listener) release() {
+	if l.handshakes != nil {
+		<-l.handshakes
+	}
+}
+
+// handshake reads the header of a connection from a trusted source and hands the connection over
+func (l *Listener) handshake(conn net.Conn) {
+	defer l.release()
+
+	if l.isTrusted(conn.RemoteAddr()) {
+		proxied, err := l.readHeader(conn)
+		if err != nil {
+			// reported before closing, so the rejection is accounted for once the client sees it
+			if l.OnReject != nil {
+				l.OnReject(conn.RemoteAddr(), err)
+			} else {
+				log.Errorf("rejected connection from %s: %s", conn.RemoteAddr(), err)
+			}
+			conn.Close()
+			return
+		}
+		conn = proxied
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *Listener) readHeader(conn net.Conn) (net.Conn, error) {
+	if l.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	reader := bufio.NewReader(conn)
+	header, err := ReadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	proxied := &Conn{Conn: conn, reader: reader, remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+	if header.Source != nil {
+		proxied.remote, proxied.local = header.Source, header.Destination
+	}
+
+	return proxied, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,232 @@
+// Package proxyproto recovers client addresses from the HAProxy PROXY protocol
+// headers (v1 and v2) sent by load balancers in front of the server.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidHeader is returned for a connection from a trusted source
+	// that doesn't start with a valid PROXY protocol header
+	ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+	signatureV1 = []byte("PROXY ")
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// maxHeaderV1 is the longest v1 header including the CRLF
+	maxHeaderV1 = 107
+
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyInet  = 0x1
+	familyInet6 = 0x2
+)
+
+// Header is the information of a PROXY protocol header.
+// Source and Destination are nil when the header carries no addresses,
+// e.g. for health checks of the balancer itself.
+type Header struct {
+	Version     int
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads a v1 or v2 header from the start of the stream
+func ReadHeader(r *bufio.Reader) (Header, error) {
+	// the first byte tells the version, so a stream without a header fails without waiting for more
+	first, err := r.Peek(1)
+	if err != nil {
+		return Header{}, errors.Wrap(ErrInvalidHeader, err.Error())
+	}
+
+	signature := signatureV1
+	if first[0] == signatureV2[0] {
+		signature = signatureV2
+	}
+
+	peek, err := r.Peek(len(signature))
+	if err != nil {
+		return Header{}, errors.Wrap(ErrInvalidHeader, err.Error())
+	}
+
+	if !bytes.Equal(peek, signature) {
+		return Header{}, ErrInvalidHeader
+	}
+
+	if first[0] == signatureV2[0] {
+		return readV2(r)
+	}
+
+	return readV1(r)
+}
+
+// readV1 parses "PROXY TCP4|TCP6|UNKNOWN <src> <dst> <sport> <dport>\r\n"
+func readV1(r *bufio.Reader) (Header, error) {
+	var line []byte
+	for len(line) < maxHeaderV1 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return Header{}, errors.Wrap(ErrInvalidHeader, err.Error())
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return Header{}, errors.Wrap(ErrInvalidHeader, "v1 header is not terminated")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return Header{Version: 1}, nil
+	}
+
+	if len(fields) != 6 {
+		return Header{}, errors.Wrap(ErrInvalidHeader, "v1 header must have 6 fields")
+	}
+
+	source, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return Header{}, err
+	}
+
+	destination, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return Header{}, err
+	}
+
+	return Header{Version: 1, Source: source, Destination: destination}, nil
+}
+
+func parseV1Addr(protocol, host, port string) (net.Addr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Wrapf(ErrInvalidHeader, "invalid address %q", host)
+	}
+
+	if (protocol == "TCP4") != (ip.To4() != nil) || (protocol != "TCP4" && protocol != "TCP6") {
+		return nil, errors.Wrapf(ErrInvalidHeader, "address %q doesn't match protocol %q", host, protocol)
+	}
+
+	// leading zeros are not allowed, so the port must round trip
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || strconv.FormatUint(n, 10) != port {
+		return nil, errors.Wrapf(ErrInvalidHeader, "invalid port %q", port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(n)}, nil
+}
+
+// readV2 parses the binary header: the signature, the version and command,
+// the address family and transport, the length of the rest and the addresses.
+// TLVs after the addresses are skipped.
+func readV2(r *bufio.Reader) (Header, error) {
+	fixed := make([]byte, len(signatureV2)+4)
+	if _, err := readFull(r, fixed); err != nil {
+		return Header{}, err
+	}
+
+	versionCommand, familyTransport := fixed[12], fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+
+	if versionCommand>>4 != 2 {
+		return Header{}, errors.Wrapf(ErrInvalidHeader, "unsupported version %d", versionCommand>>4)
+	}
+
+	payload := make([]byte, length)
+	if _, err := readFull(r, payload); err != nil {
+		return Header{}, err
+	}
+
+	switch versionCommand & 0x0F {
+	case commandLocal:
+		// a connection of the balancer itself
+		return Header{Version: 2}, nil
+	case commandProxy:
+	default:
+		return Header{}, errors.Wrapf(ErrInvalidHeader, "unsupported command %d", versionCommand&0x0F)
+	}
+
+	var size int
+	switch familyTransport >> 4 {
+	case familyInet:
+		size = net.IPv4len
+	case familyInet6:
+		size = net.IPv6len
+	default:
+		// unix sockets and unspecified families carry no usable address
+		return Header{Version: 2}, nil
+	}
+
+	if len(payload) < 2*size+4 {
+		return Header{}, errors.Wrap(ErrInvalidHeader, "v2 header is too short for its addresses")
+	}
+
+	source := &net.TCPAddr{
+		IP:   net.IP(payload[:size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size:])),
+	}
+	destination := &net.TCPAddr{
+		IP:   net.IP(payload[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size+2:])),
+	}
+
+	return Header{Version: 2, Source: source, Destination: destination}, nil
+}
+
+func readFull(r *bufio.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if err != nil {
+		return n, errors.Wrap(ErrInvalidHeader, err.Error())
+	}
+
+	return n, nil
+}
+
+// ParseCIDRs parses a comma-separated list of CIDRs, bare IPs are taken as single hosts
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
@@ -0,0 +1,216 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// headerV2 builds a v2 PROXY header for TCP over IPv4 followed by the TLV bytes
+func headerV2(command byte, src, dst string, srcPort, dstPort uint16, tlv []byte) []byte {
+	payload := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	payload = binary.BigEndian.AppendUint16(payload, dstPort)
+	payload = append(payload, tlv...)
+
+	header := append([]byte{}, signatureV2...)
+	header = append(header, 0x20|command, familyInet<<4|0x1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+
+	return append(header, payload...)
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		source     string
+		shouldFail bool
+	}{
+		{name: "v1 tcp4", input: "PROXY TCP4 203.0.113.7 192.0.2.1 56324 9000\r\n", source: "203.0.113.7:56324"},
+		{name: "v1 tcp6", input: "PROXY TCP6 2001:db8::7 2001:db8::1 56324 9000\r\n", source: "[2001:db8::7]:56324"},
+		{name: "v1 unknown", input: "PROXY UNKNOWN\r\n"},
+		{name: "v2 proxy", input: string(headerV2(commandProxy, "203.0.113.7", "192.0.2.1", 56324, 9000, []byte{0x04, 0x00, 0x01, 0x00})), source: "203.0.113.7:56324"},
+		{name: "v2 local", input: string(headerV2(commandLocal, "0.0.0.0", "0.0.0.0", 0, 0, nil))},
+		{name: "no header", input: "GET / HTTP/1.1\r\n", shouldFail: true},
+		{name: "v1 without crlf", input: "PROXY TCP4 203.0.113.7 192.0.2.1 56324 9000\n", shouldFail: true},
+		{name: "v1 too long", input: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", shouldFail: true},
+		{name: "v1 family mismatch", input: "PROXY TCP4 2001:db8::7 192.0.2.1 56324 9000\r\n", shouldFail: true},
+		{name: "v1 bad port", input: "PROXY TCP4 203.0.113.7 192.0.2.1 056324 9000\r\n", shouldFail: true},
+		{name: "v1 missing fields", input: "PROXY TCP4 203.0.113.7 192.0.2.1\r\n", shouldFail: true},
+		{name: "v2 truncated", input: string(headerV2(commandProxy, "203.0.113.7", "192.0.2.1", 1, 2, nil)[:20]), shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tc.input + "payload"))
+
+			header, err := ReadHeader(reader)
+			if tc.shouldFail {
+				assert.True(t, errors.Is(err, ErrInvalidHeader), err)
+				return
+			}
+
+			assert.NoError(t, err)
+			if tc.source == "" {
+				assert.Nil(t, header.Source)
+			} else {
+				assert.Equal(t, tc.source, header.Source.String())
+			}
+
+			// the stream continues right after the header
+			rest, _ := io.ReadAll(reader)
+			assert.Equal(t, "payload", string(rest))
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs("10.0.0.0/8, 192.0.2.1,2001:db8::/32")
+	assert.NoError(t, err)
+	assert.Len(t, nets, 3)
+
+	assert.True(t, nets[0].Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, nets[1].Contains(net.ParseIP("192.0.2.1")))
+	assert.False(t, nets[1].Contains(net.ParseIP("192.0.2.2")))
+	assert.True(t, nets[2].Contains(net.ParseIP("2001:db8::7")))
+
+	_, err = ParseCIDRs("10.0.0.0/33")
+	assert.Error(t, err)
+
+	_, err = ParseCIDRs("balancer")
+	assert.Error(t, err)
+}
+
+func TestListener(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	trusted, _ := ParseCIDRs("127.0.0.1")
+	l := NewListener(raw, trusted, 200*time.Millisecond)
+	defer l.Close()
+
+	rejected := make(chan error, 1)
+	l.OnReject = func(addr net.Addr, err error) {
+		rejected <- err
+	}
+
+	// a stalled connection doesn't hold up the next one
+	stalled, err := net.Dial("tcp", raw.Addr().String())
+	assert.NoError(t, err)
+	defer stalled.Close()
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 9000\r\nhello"))
+
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "192.0.2.1:9000", conn.LocalAddr().String())
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	select {
+	case err := <-rejected:
+		assert.True(t, errors.Is(err, ErrInvalidHeader))
+	case <-time.After(time.Second):
+		t.Fatal("stalled connection wasn't rejected")
+	}
+
+	l.Close()
+	_, err = l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestListener_RejectsBeforeClosing(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	trusted, _ := ParseCIDRs("127.0.0.1")
+	l := NewListener(raw, trusted, 100*time.Millisecond)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// the client reads while the rejection is reported, a closed connection would return EOF
+	open := make(chan error, 1)
+	l.OnReject = func(addr net.Addr, err error) {
+		client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = client.Read(make([]byte, 1))
+		open <- err
+	}
+	go l.Accept()
+
+	select {
+	case err := <-open:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "the connection was closed before OnReject")
+	case <-time.After(time.Second):
+		t.Fatal("connection wasn't rejected")
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestListener_MaxHandshakes(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	trusted, _ := ParseCIDRs("127.0.0.1")
+	l := NewListener(raw, trusted, 200*time.Millisecond)
+	l.MaxHandshakes = 1
+	l.OnReject = func(net.Addr, error) {}
+	defer l.Close()
+
+	// the only handshake is taken by a stalled connection
+	stalled, err := net.Dial("tcp", raw.Addr().String())
+	assert.NoError(t, err)
+	defer stalled.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 9000\r\n"))
+
+	select {
+	case <-accepted:
+		t.Fatal("the header was read while the stalled connection held the handshake")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the place is freed once the stalled connection is rejected
+	select {
+	case conn := <-accepted:
+		assert.Equal(t, "203.0.113.7:56324", conn.RemoteAddr().String())
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("the connection wasn't accepted")
+	}
+}
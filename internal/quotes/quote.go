@@ -0,0 +1,67 @@
+package quotes
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned when no quote matches the requested filter
+var ErrNotFound = errors.New("no quotes found")
+
+// authorSeparator separates quote text from its author in the plain text form
+const authorSeparator = " - "
+
+type Quote struct {
+	ID     int
+	Text   string
+	Author string
+	Tags   []string
+}
+
+// Filter narrows down the set of quotes a random quote is picked from.
+// Empty fields match any quote.
+type Filter struct {
+	Author   string
+	Category string
+}
+
+// Parse splits the plain text form "<text> - <author>" into a Quote
+func Parse(s string) Quote {
+	i := strings.LastIndex(s, authorSeparator)
+	if i < 0 {
+		return Quote{Text: s}
+	}
+
+	return Quote{
+		Text:   strings.TrimSpace(s[:i]),
+		Author: strings.TrimSpace(s[i+len(authorSeparator):]),
+	}
+}
+
+// String returns the plain text form of the quote, as served to line protocol clients
+func (q Quote) String() string {
+	if q.Author == "" {
+		return q.Text
+	}
+
+	return q.Text + authorSeparator + q.Author
+}
+
+// Matches reports whether the quote satisfies the filter
+func (q Quote) Matches(f Filter) bool {
+	if f.Author != "" && !strings.EqualFold(q.Author, f.Author) {
+		return false
+	}
+
+	if f.Category == "" {
+		return true
+	}
+
+	for _, tag := range q.Tags {
+		if strings.EqualFold(tag, f.Category) {
+			return true
+		}
+	}
+
+	return false
+}
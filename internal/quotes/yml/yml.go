@@ -1,39 +1,114 @@
 package quotes
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
 
+	"github.com/zhashkevych/quotes-server/internal/quotes"
 	"gopkg.in/yaml.v2"
 )
 
 type Quotes struct {
-	Quotes []string `yaml:"quotes"`
+	Quotes []Entry `yaml:"quotes"`
+}
+
+// Entry is a single quote in the YML file. It is either a plain string
+// in the "<text> - <author>" form or a mapping with explicit fields.
+type Entry struct {
+	ID     int      `yaml:"id"`
+	Text   string   `yaml:"text"`
+	Author string   `yaml:"author"`
+	Tags   []string `yaml:"tags"`
+}
+
+func (e *Entry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err == nil {
+		q := quotes.Parse(text)
+		e.Text, e.Author = q.Text, q.Author
+		return nil
+	}
+
+	type plain Entry
+	return unmarshal((*plain)(e))
 }
 
 type YMLService struct {
-	quotes []string
+	quotes     []quotes.Quote
+	categories []string
 }
 
+// NewYMLService loads the quotes of the file. Entries without an ID get their position
+// in the file, starting from 1, and an error is returned if two quotes have the same ID.
 func NewYMLService(filepath string) (*YMLService, error) {
 	ymlData, err := os.ReadFile(filepath)
 	if err != nil {
 		return nil, err
 	}
 
-	var quotes Quotes
-	err = yaml.Unmarshal(ymlData, &quotes)
+	var file Quotes
+	err = yaml.Unmarshal(ymlData, &file)
 	if err != nil {
 		return nil, err
 	}
 
-	return &YMLService{quotes.Quotes}, nil
+	list := make([]quotes.Quote, 0, len(file.Quotes))
+	tags := make(map[string]struct{})
+	positions := make(map[int]int, len(file.Quotes))
+	for i, e := range file.Quotes {
+		id := e.ID
+		if id == 0 {
+			id = i + 1
+		}
+
+		if first, ok := positions[id]; ok {
+			return nil, fmt.Errorf("quotes %d and %d have the same id %d", first+1, i+1, id)
+		}
+		positions[id] = i
+
+		list = append(list, quotes.Quote{ID: id, Text: e.Text, Author: e.Author, Tags: e.Tags})
+
+		for _, tag := range e.Tags {
+			tags[strings.ToLower(tag)] = struct{}{}
+		}
+	}
+
+	categories := make([]string, 0, len(tags))
+	for tag := range tags {
+		categories = append(categories, tag)
+	}
+	sort.Strings(categories)
+
+	return &YMLService{quotes: list, categories: categories}, nil
 }
 
-func (s *YMLService) GetRandomQuote() string {
-	if len(s.quotes) == 0 {
-		return ""
+func (s *YMLService) GetRandomQuote(filter quotes.Filter) (quotes.Quote, error) {
+	if filter == (quotes.Filter{}) {
+		if len(s.quotes) == 0 {
+			return quotes.Quote{}, quotes.ErrNotFound
+		}
+
+		return s.quotes[rand.Intn(len(s.quotes))], nil
+	}
+
+	var matched []quotes.Quote
+	for _, q := range s.quotes {
+		if q.Matches(filter) {
+			matched = append(matched, q)
+		}
+	}
+
+	if len(matched) == 0 {
+		return quotes.Quote{}, quotes.ErrNotFound
 	}
 
-	return s.quotes[rand.Intn(len(s.quotes))]
+	return matched[rand.Intn(len(matched))], nil
+}
+
+// Categories returns the sorted list of distinct quote tags
+func (s *YMLService) Categories() []string {
+	return s.categories
 }
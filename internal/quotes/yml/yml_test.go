@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
 )
 
 func TestNewYMLService(t *testing.T) {
@@ -22,6 +23,49 @@ func TestNewYMLService(t *testing.T) {
 	assert.Equal(t, len(expectedQuotes), len(service.quotes))
 }
 
+func TestNewYMLService_StructuredEntries(t *testing.T) {
+	content := `quotes:
+  - "Knowing yourself is the beginning of all wisdom. - Aristotle"
+  - id: 42
+    text: "Patience is the companion of wisdom."
+    author: "Saint Augustine"
+    tags: [Wisdom, patience]
+`
+	filepath, err := createTempFile(content)
+	assert.NoError(t, err)
+
+	defer os.Remove(filepath)
+
+	service, err := NewYMLService(filepath)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []quotes.Quote{
+		{ID: 1, Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"},
+		{ID: 42, Text: "Patience is the companion of wisdom.", Author: "Saint Augustine", Tags: []string{"Wisdom", "patience"}},
+	}, service.quotes)
+	assert.Equal(t, []string{"patience", "wisdom"}, service.Categories())
+}
+
+func TestNewYMLService_DuplicateIDs(t *testing.T) {
+	// the explicit id of the third quote is the position of the first one
+	content := `quotes:
+  - "Knowing yourself is the beginning of all wisdom. - Aristotle"
+  - id: 42
+    text: "Patience is the companion of wisdom."
+    author: "Saint Augustine"
+  - id: 1
+    text: "Well begun is half done."
+    author: "Aristotle"
+`
+	filepath, err := createTempFile(content)
+	assert.NoError(t, err)
+
+	defer os.Remove(filepath)
+
+	_, err = NewYMLService(filepath)
+	assert.EqualError(t, err, "quotes 1 and 3 have the same id 1")
+}
+
 func TestGetRandomQuote(t *testing.T) {
 	rand.New(rand.NewSource(time.Now().Unix()))
 
@@ -35,21 +79,44 @@ func TestGetRandomQuote(t *testing.T) {
 	assert.NoError(t, err)
 
 	for i := 0; i < 10; i++ {
-		quote := service.GetRandomQuote()
-		if quote == "" {
-			t.Error("Expected a non-empty quote")
-		}
+		quote, err := service.GetRandomQuote(quotes.Filter{})
+		assert.NoError(t, err)
 
-		assert.NotEmpty(t, quote)
-		assert.Equal(t, true, contains(expectedQuotes, quote))
+		assert.NotEmpty(t, quote.Text)
+		assert.Equal(t, true, contains(expectedQuotes, quote.String()))
 	}
 }
 
-func createTempQuotesFile(quotes []string) (string, error) {
+func TestGetRandomQuote_Filter(t *testing.T) {
+	expectedQuotes := []string{"Test quote 1 - Socrates", "Test quote 2 - Lao Tzu"}
+	filepath, err := createTempQuotesFile(expectedQuotes)
+	assert.NoError(t, err)
+
+	defer os.Remove(filepath)
+
+	service, err := NewYMLService(filepath)
+	assert.NoError(t, err)
+
+	quote, err := service.GetRandomQuote(quotes.Filter{Author: "lao tzu"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test quote 2 - Lao Tzu", quote.String())
+
+	_, err = service.GetRandomQuote(quotes.Filter{Author: "Aristotle"})
+	assert.ErrorIs(t, err, quotes.ErrNotFound)
+
+	_, err = service.GetRandomQuote(quotes.Filter{Category: "wisdom"})
+	assert.ErrorIs(t, err, quotes.ErrNotFound)
+}
+
+func createTempQuotesFile(list []string) (string, error) {
 	content := "quotes:\n"
-	for _, q := range quotes {
+	for _, q := range list {
 		content += "  - \"" + q + "\"\n"
 	}
+	return createTempFile(content)
+}
+
+func createTempFile(content string) (string, error) {
 	tmpfile, err := os.CreateTemp("", "quotes*.yml")
 	if err != nil {
 		return "", err
@@ -0,0 +1,174 @@
+package reputation
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultWindow = time.Minute
+
+type Config struct {
+	// Window is the period over which failed attempts are counted
+	Window time.Duration
+	// BanThreshold is the number of failed attempts within the window that gets a client banned.
+	// Zero disables bans.
+	BanThreshold int
+	// BanDuration is how long a ban lasts
+	BanDuration time.Duration
+}
+
+type record struct {
+	windowStart time.Time
+	failures    int
+	successes   int
+	bannedUntil time.Time
+}
+
+// Tracker keeps per-client counters of failed and successful proof-of-work attempts
+// and bans clients that fail too often
+type Tracker struct {
+	config Config
+	now    func() time.Time
+
+	records     map[string]*record
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+func New(config Config) *Tracker {
+	if config.Window <= 0 {
+		config.Window = defaultWindow
+	}
+
+	return &Tracker{
+		config:  config,
+		now:     time.Now,
+		records: make(map[string]*record),
+	}
+}
+
+// Reconfigure replaces the ban threshold and duration, the counters and the bans in place are kept
+func (t *Tracker) Reconfigure(config Config) {
+	if config.Window <= 0 {
+		config.Window = defaultWindow
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.config = config
+}
+
+// RecordFailure counts a failed attempt of the client and bans it once the threshold is reached
+func (t *Tracker) RecordFailure(client string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	r := t.current(client, now)
+	r.failures++
+
+	if t.config.BanThreshold > 0 && r.failures >= t.config.BanThreshold {
+		r.bannedUntil = now.Add(t.config.BanDuration)
+	}
+}
+
+// RecordSuccess counts a successful attempt of the client
+func (t *Tracker) RecordSuccess(client string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.current(client, t.now()).successes++
+}
+
+// IsBanned reports whether the client is banned at the moment
+func (t *Tracker) IsBanned(client string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.records[client]
+	return ok && t.now().Before(r.bannedUntil)
+}
+
+// Ban is a client banned at the moment
+type Ban struct {
+	Client string
+	Until  time.Time
+}
+
+// Bans returns the clients banned at the moment sorted by client
+func (t *Tracker) Bans() []Ban {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	var bans []Ban
+	for client, r := range t.records {
+		if now.Before(r.bannedUntil) {
+			bans = append(bans, Ban{Client: client, Until: r.bannedUntil})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Client < bans[j].Client })
+
+	return bans
+}
+
+// Lift ends the ban of the client and forgets its failed attempts,
+// it reports whether the client was banned
+func (t *Tracker) Lift(client string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.records[client]
+	if !ok || !t.now().Before(r.bannedUntil) {
+		return false
+	}
+	r.bannedUntil, r.failures = time.Time{}, 0
+
+	return true
+}
+
+// Failures returns the number of failed attempts of the client within the current window
+func (t *Tracker) Failures(client string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.records[client]
+	if !ok || t.now().Sub(r.windowStart) >= t.config.Window {
+		return 0
+	}
+
+	return r.failures
+}
+
+// current returns the client's record, starting a new window if the previous one has passed
+func (t *Tracker) current(client string, now time.Time) *record {
+	t.cleanup(now)
+
+	r, ok := t.records[client]
+	if !ok {
+		r = &record{windowStart: now}
+		t.records[client] = r
+	}
+
+	if now.Sub(r.windowStart) >= t.config.Window {
+		r.windowStart, r.failures, r.successes = now, 0, 0
+	}
+
+	return r
+}
+
+// cleanup drops the records of clients that are neither active nor banned, at most once per window
+func (t *Tracker) cleanup(now time.Time) {
+	if now.Sub(t.lastCleanup) < t.config.Window {
+		return
+	}
+	t.lastCleanup = now
+
+	for client, r := range t.records {
+		if now.Sub(r.windowStart) >= t.config.Window && !now.Before(r.bannedUntil) {
+			delete(t.records, client)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package reputation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_Ban(t *testing.T) {
+	now := time.Now()
+
+	tracker := New(Config{Window: time.Minute, BanThreshold: 3, BanDuration: time.Hour})
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordFailure("10.0.0.1")
+	tracker.RecordFailure("10.0.0.1")
+	tracker.RecordSuccess("10.0.0.1")
+	assert.False(t, tracker.IsBanned("10.0.0.1"))
+	assert.Equal(t, 2, tracker.Failures("10.0.0.1"))
+
+	tracker.RecordFailure("10.0.0.1")
+	assert.True(t, tracker.IsBanned("10.0.0.1"))
+	assert.False(t, tracker.IsBanned("10.0.0.2"))
+
+	// the ban outlives the window
+	now = now.Add(30 * time.Minute)
+	assert.True(t, tracker.IsBanned("10.0.0.1"))
+	assert.Equal(t, 0, tracker.Failures("10.0.0.1"))
+
+	now = now.Add(time.Hour)
+	assert.False(t, tracker.IsBanned("10.0.0.1"))
+}
+
+func TestTracker_Window(t *testing.T) {
+	now := time.Now()
+
+	tracker := New(Config{Window: time.Minute, BanThreshold: 2, BanDuration: time.Hour})
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordFailure("10.0.0.1")
+	now = now.Add(2 * time.Minute)
+	tracker.RecordFailure("10.0.0.1")
+
+	assert.False(t, tracker.IsBanned("10.0.0.1"))
+	assert.Equal(t, 1, tracker.Failures("10.0.0.1"))
+}
+
+func TestTracker_BansDisabled(t *testing.T) {
+	tracker := New(Config{})
+
+	for i := 0; i < 100; i++ {
+		tracker.RecordFailure("10.0.0.1")
+	}
+
+	assert.False(t, tracker.IsBanned("10.0.0.1"))
+	assert.Equal(t, 100, tracker.Failures("10.0.0.1"))
+}
+
+func TestTracker_Cleanup(t *testing.T) {
+	now := time.Now()
+
+	tracker := New(Config{Window: time.Minute, BanThreshold: 1, BanDuration: time.Hour})
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordSuccess("10.0.0.1")
+	tracker.RecordFailure("10.0.0.2")
+
+	now = now.Add(2 * time.Minute)
+	tracker.RecordSuccess("10.0.0.3")
+
+	_, active := tracker.records["10.0.0.1"]
+	_, banned := tracker.records["10.0.0.2"]
+	assert.False(t, active)
+	assert.True(t, banned)
+}
+
+func TestTracker_Reconfigure(t *testing.T) {
+	tracker := New(Config{})
+
+	tracker.RecordFailure("10.0.0.1")
+	assert.False(t, tracker.IsBanned("10.0.0.1"))
+
+	// the failures counted so far are kept
+	tracker.Reconfigure(Config{BanThreshold: 2, BanDuration: time.Hour})
+	tracker.RecordFailure("10.0.0.1")
+	assert.True(t, tracker.IsBanned("10.0.0.1"))
+}
+
+func TestTracker_Lift(t *testing.T) {
+	now := time.Now()
+	tracker := New(Config{Window: time.Minute, BanThreshold: 1, BanDuration: time.Hour})
+	tracker.now = func() time.Time { return now }
+
+	tracker.RecordFailure("10.0.0.2")
+	tracker.RecordFailure("10.0.0.1")
+	tracker.RecordSuccess("10.0.0.3")
+
+	assert.Equal(t, []Ban{
+		{Client: "10.0.0.1", Until: now.Add(time.Hour)},
+		{Client: "10.0.0.2", Until: now.Add(time.Hour)},
+	}, tracker.Bans())
+
+	assert.True(t, tracker.Lift("10.0.0.1"))
+	assert.False(t, tracker.IsBanned("10.0.0.1"))
+	assert.Equal(t, 0, tracker.Failures("10.0.0.1"))
+
+	// only bans in place can be lifted
+	assert.False(t, tracker.Lift("10.0.0.1"))
+	assert.False(t, tracker.Lift("10.0.0.3"))
+
+	assert.Equal(t, []Ban{{Client: "10.0.0.2", Until: now.Add(time.Hour)}}, tracker.Bans())
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// the outcomes of a connection in the access log besides the codes of the protocol errors
+const (
+	// outcomeOK means quotes were served and the last response wasn't an error
+	outcomeOK = "OK"
+	// outcomeClosed means the connection ended before anything was served, e.g. the client went away
+	outcomeClosed = "CLOSED"
+	// outcomeTLSFailed means the TLS handshake failed
+	outcomeTLSFailed = "TLS_FAILED"
+)
+
+// WithAccessLog writes a record of every connection to the logger once it's closed:
+// its ID, remote address, difficulty, solve duration, outcome, bytes in and out and the quote served.
+// sampleRate is the share of the connections that are logged, from 0 to 1.
+func WithAccessLog(logger log.FieldLogger, sampleRate float64) Option {
+	return func(s *TCPServer) {
+		s.accessLog = logger
+		s.accessSampleRate = sampleRate
+	}
+}
+
+// accessRecord collects what the access log tells about a connection
+type accessRecord struct {
+	id         uint64
+	listener   string
+	remoteAddr string
+	accepted   time.Time
+	conn       *countingConn
+
+	// difficulty is of the last challenge issued
+	difficulty int
+	// solveDuration adds up the time the client took to solve its challenges
+	solveDuration time.Duration
+	// outcome is outcomeOK, outcomeClosed, outcomeTLSFailed or the code of the last error sent
+	outcome string
+	// quoteID is of the last quote served, zero if there's none
+	quoteID int
+}
+
+func newAccessRecord(id uint64, conn *countingConn, l *listener, accepted time.Time) *accessRecord {
+	return &accessRecord{
+		id:         id,
+		listener:   l.label,
+		remoteAddr: remoteAddr(conn),
+		accepted:   accepted,
+		conn:       conn,
+		outcome:    outcomeClosed,
+	}
+}
+
+// sent updates the record with a message written to the client
+func (r *accessRecord) sent(msg protocol.Message) {
+	switch msg.Type {
+	case protocol.TypeError:
+		r.outcome = msg.Code
+	case protocol.TypeQuote:
+		r.outcome = outcomeOK
+		r.quoteID = msg.Quote.ID
+	}
+}
+
+// logAccess writes the record of a closed connection if it's sampled
+func (s *TCPServer) logAccess(r *accessRecord) {
+	if s.accessLog == nil || (s.accessSampleRate < 1 && rand.Float64() >= s.accessSampleRate) {
+		return
+	}
+
+	s.accessLog.WithFields(log.Fields{
+		"conn_id":     r.id,
+		"listener":    r.listener,
+		"remote_addr": r.remoteAddr,
+		"difficulty":  r.difficulty,
+		"solve_ms":    durationMs(r.solveDuration),
+		"duration_ms": durationMs(s.now().Sub(r.accepted)),
+		"outcome":     r.outcome,
+		"bytes_in":    r.conn.in.Load(),
+		"bytes_out":   r.conn.out.Load(),
+		"quote_id":    r.quoteID,
+	}).Info("access")
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// countingConn counts the bytes read from and written to the connection
+type countingConn struct {
+	net.Conn
+	in  atomic.Int64
+	out atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(int64(n))
+
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(int64(n))
+
+	return n, err
+}
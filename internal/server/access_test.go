@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+func TestWithAccessLog(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{ID: 7, Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(2).Return("challenge", nil).Times(2)
+	powManager.EXPECT().VerifySolution("challenge", 42).Return(true, nil)
+	powManager.EXPECT().VerifySolution("challenge", 13).Return(false, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	logger, _ := test.NewNullLogger()
+	accessLog, hook := test.NewNullLogger()
+	l := newPipeListener()
+
+	server, err := New(
+		WithQuotes(quoter),
+		WithProofOfWork(powManager),
+		WithPowDifficulty(2),
+		WithLogger(logger),
+		WithClock(stepClock(100*time.Millisecond)),
+		WithAccessLog(accessLog, 1),
+		WithListenerFactory(func(network, address string) (net.Listener, error) { return l, nil }),
+		WithListener(ListenerConfig{Label: "memory", Network: "tcp", Address: "in-memory"}),
+	)
+	assert.NoError(t, err)
+
+	go server.ListenAndServe()
+	<-server.Ready()
+	defer server.CloseListeners()
+
+	exchange := func(nonce int) {
+		conn := l.dial()
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		_, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+
+		fmt.Fprintln(conn, nonce)
+		_, err = reader.ReadString('\n')
+		assert.NoError(t, err)
+	}
+	exchange(42)
+	exchange(13)
+
+	assert.Eventually(t, func() bool { return len(hook.AllEntries()) == 2 }, time.Second, 10*time.Millisecond)
+
+	entries := hook.AllEntries()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Data["conn_id"].(uint64) < entries[j].Data["conn_id"].(uint64) })
+
+	challengeBytes := int64(len("challenge\n"))
+
+	served := entries[0].Data
+	assert.Equal(t, "access", entries[0].Message)
+	assert.Equal(t, "memory", served["listener"])
+	assert.Equal(t, "pipe", served["remote_addr"])
+	assert.Equal(t, 2, served["difficulty"])
+	assert.Equal(t, 100.0, served["solve_ms"])
+	assert.Equal(t, outcomeOK, served["outcome"])
+	assert.Equal(t, int64(len("42\n")), served["bytes_in"])
+	assert.Equal(t, challengeBytes+int64(len(quote.String()+"\n")), served["bytes_out"])
+	assert.Equal(t, 7, served["quote_id"])
+
+	rejected := entries[1].Data
+	assert.Equal(t, protocol.ErrCodeWrongSolution, rejected["outcome"])
+	assert.Equal(t, challengeBytes+int64(len(protocol.ErrCodeWrongSolution+" "+IncorrectSolutionResonse+"\n")), rejected["bytes_out"])
+	assert.Equal(t, 0, rejected["quote_id"])
+}
+
+func TestWithAccessLog_Sampling(t *testing.T) {
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	for _, tc := range []struct {
+		sampleRate float64
+		records    int
+	}{
+		{sampleRate: 0, records: 0},
+		{sampleRate: 1, records: 1},
+	} {
+		accessLog, hook := test.NewNullLogger()
+		server := NewTCPServer(0, 4, nil, nil, WithAccessLog(accessLog, tc.sampleRate))
+
+		server.logAccess(newAccessRecord(1, &countingConn{Conn: conn}, &listener{label: "tcp"}, time.Now()))
+
+		assert.Len(t, hook.AllEntries(), tc.records, "sample rate %v", tc.sampleRate)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import "sort"
+
+// Connections returns the connections being served sorted by ID,
+// connections waiting for a worker aren't included
+func (s *TCPServer) Connections() []ConnInfo {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	list := make([]ConnInfo, 0, len(s.connections))
+	for _, state := range s.connections {
+		list = append(list, state.info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
+
+// KillConnection closes the connection with the ID at once, whatever its exchange is doing.
+// It reports whether the connection was being served.
+func (s *TCPServer) KillConnection(id uint64) bool {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	for conn, state := range s.connections {
+		if state.info.ID == id {
+			s.logger.Infof("killing connection %d from %s", id, remoteAddr(conn))
+			state.kill()
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+)
+
+func TestTCPServer_KillConnection(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	powManager.EXPECT().GenerateChallenge(4).Return("challenge", nil)
+
+	server := NewTCPServer(0, 4, mocks.NewMockQuoter(c), powManager)
+
+	go server.ListenAndServe()
+	<-server.Ready()
+	defer server.CloseListeners()
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	connections := server.Connections()
+	if assert.Len(t, connections, 1) {
+		assert.Equal(t, uint64(1), connections[0].ID)
+		assert.Equal(t, "tcp", connections[0].Listener)
+		assert.Equal(t, conn.LocalAddr().String(), connections[0].RemoteAddr.String())
+	}
+
+	assert.False(t, server.KillConnection(2))
+	assert.True(t, server.KillConnection(1))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+
+	assert.Eventually(t, func() bool { return len(server.Connections()) == 0 }, time.Second, 10*time.Millisecond)
+}
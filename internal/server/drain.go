@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ShuttingDownResponse tells clients the server is going away
+const ShuttingDownResponse = "Server is shutting down"
+
+// drainPollInterval is how often Shutdown checks whether the connections have finished
+const drainPollInterval = 10 * time.Millisecond
+
+// errDraining ends a session that waits for its next command while the server drains
+var errDraining = errors.New("server is draining")
+
+// DrainReport tells how the connections open at shutdown ended
+type DrainReport struct {
+	// Drained connections finished their exchanges before the deadline
+	Drained int
+	// Killed connections were still busy at the deadline and were closed
+	Killed int
+}
+
+// drainOutcome is how a connection that was open when draining started ended
+type drainOutcome int
+
+const (
+	drainPending drainOutcome = iota
+	// drainFinished connections ended their exchanges themselves
+	drainFinished
+	// drainKilled connections were closed by force
+	drainKilled
+)
+
+// connState tracks whether a connection waits between the exchanges of a session,
+// where draining may end it without losing any work of the client
+type connState struct {
+	// info describes the connection, it doesn't change
+	info ConnInfo
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	idle     bool
+	draining bool
+	outcome  drainOutcome
+}
+
+// enterIdle marks the connection as waiting for the next command and sets its read deadline,
+// a draining connection gets errDraining instead
+func (st *connState) enterIdle(conn net.Conn, deadline time.Time) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.draining {
+		return errDraining
+	}
+	st.idle = true
+
+	return conn.SetReadDeadline(deadline)
+}
+
+func (st *connState) leaveIdle() {
+	st.mutex.Lock()
+	st.idle = false
+	st.mutex.Unlock()
+}
+
+// drain marks the connection as draining, cutting the wait of an idle session short
+func (st *connState) drain() {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.draining = true
+	if st.idle {
+		st.conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (st *connState) isDraining() bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	return st.draining
+}
+
+// finish records that the connection ended its exchanges
+func (st *connState) finish() {
+	st.settle(drainFinished)
+}
+
+// kill closes the connection by force, whatever its exchange is doing
+func (st *connState) kill() {
+	st.settle(drainKilled)
+	st.conn.Close()
+}
+
+// settle records how a draining connection ended, the first outcome holds
+func (st *connState) settle(outcome drainOutcome) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.draining && st.outcome == drainPending {
+		st.outcome = outcome
+	}
+}
+
+func (st *connState) drainOutcome() drainOutcome {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	return st.outcome
+}
+
+// Drain stops the server taking new work while it keeps running: the listeners are closed,
+// readiness fails, in-flight exchanges run to their end, sessions end after their current
+// command and connections still waiting for a worker are told that the server is going away
+func (s *TCPServer) Drain() {
+	s.drain()
+}
+
+// drain starts draining and returns the states of the connections open at that time
+func (s *TCPServer) drain() []*connState {
+	s.CloseListeners()
+
+	s.connMutex.Lock()
+	s.draining = true
+	draining := make([]*connState, 0, len(s.connections))
+	for _, state := range s.connections {
+		state.drain()
+		draining = append(draining, state)
+	}
+	s.connMutex.Unlock()
+
+	s.logger.Infof("draining %d connections", len(draining))
+
+	return draining
+}
+
+// Shutdown drains the server like Drain and waits until the context is done for the
+// connections to end. Then the remaining connections are killed and the error of the
+// context is returned if there were any. The report counts the connections that were
+// open when Shutdown started draining.
+func (s *TCPServer) Shutdown(ctx context.Context) (DrainReport, error) {
+	draining := s.drain()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+wait:
+	for s.openConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	s.cancel()
+
+	s.connMutex.Lock()
+	for conn, state := range s.connections {
+		state.kill()
+		delete(s.connections, conn)
+	}
+	s.connMutex.Unlock()
+
+	// a connection is finished before it's untracked, so every outcome is settled by now
+	var report DrainReport
+	for _, state := range draining {
+		if state.drainOutcome() == drainKilled {
+			report.Killed++
+		} else {
+			report.Drained++
+		}
+	}
+
+	s.metricsMutex.Lock()
+	s.drainReport = report
+	s.metricsMutex.Unlock()
+
+	s.logMetrics()
+
+	if report.Killed > 0 {
+		return report, ctx.Err()
+	}
+
+	return report, nil
+}
+
+// openConnections counts the connections being served and the ones queued for a worker,
+// which are told that the server is going away once they get one
+func (s *TCPServer) openConnections() int {
+	queued := s.stats().queueDepth
+
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	return len(s.connections) + queued
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+func TestTCPServer_ShutdownDrains(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil).Times(2)
+	powManager.EXPECT().VerifySolution("greeting", 42).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithKeepAlive(GatePerCommand, 1))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	connect := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", server.getAddr())
+		assert.NoError(t, err)
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	// a client solving the greeting challenge
+	solving, solvingReader := connect()
+	defer solving.Close()
+
+	_, err := solvingReader.ReadString('\n')
+	assert.NoError(t, err)
+
+	// a session waiting for its next command
+	idle, idleReader := connect()
+	defer idle.Close()
+
+	idleReader.ReadString('\n')
+	fmt.Fprintln(idle, "PING")
+	response, err := idleReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "PONG\n", response)
+
+	type result struct {
+		report DrainReport
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		report, err := server.Shutdown(ctx)
+		done <- result{report, err}
+	}()
+
+	// the idle session is ended right away
+	response, err = idleReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeShuttingDown+" "+ShuttingDownResponse+"\n", response)
+
+	// the listener is closed before the connections are drained
+	_, err = net.Dial("tcp", server.getAddr())
+	assert.Error(t, err)
+
+	// the work of the solving client isn't lost
+	fmt.Fprintln(solving, 42)
+	response, err = solvingReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, quote.String()+"\n", response)
+
+	res := <-done
+	assert.NoError(t, res.err)
+	assert.Equal(t, DrainReport{Drained: 2, Killed: 0}, res.report)
+	assert.Equal(t, 0, server.stats().totalTimeouts())
+}
+
+func TestTCPServer_ShutdownKills(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil)
+
+	server := NewTCPServer(0, 4, mocks.NewMockQuoter(c), powManager)
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	report, err := server.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, DrainReport{Drained: 0, Killed: 1}, report)
+
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestTCPServer_ShutdownTellsQueued(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil)
+	powManager.EXPECT().VerifySolution("greeting", 42).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithWorkerPool(PoolPolicy{Workers: 1, QueueSize: 1}))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+
+	// the only worker is busy with the first client
+	busy, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer busy.Close()
+
+	busyReader := bufio.NewReader(busy)
+	_, err = busyReader.ReadString('\n')
+	assert.NoError(t, err)
+
+	queued, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer queued.Close()
+
+	assert.Eventually(t, func() bool { return server.stats().queueDepth == 1 }, time.Second, 10*time.Millisecond)
+
+	done := make(chan DrainReport)
+	go func() {
+		report, err := server.Shutdown(context.Background())
+		assert.NoError(t, err)
+		done <- report
+	}()
+
+	fmt.Fprintln(busy, 42)
+	response, err := busyReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, quote.String()+"\n", response)
+
+	// the queued connection was accepted before the listener closed, it isn't dropped silently
+	response, err = bufio.NewReader(queued).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeShuttingDown+" "+ShuttingDownResponse+"\n", response)
+
+	// only the connections open when draining started are reported
+	assert.Equal(t, DrainReport{Drained: 1, Killed: 0}, <-done)
+}
+
+func TestTCPServer_Drain(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil)
+	powManager.EXPECT().VerifySolution("greeting", 42).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	server := NewTCPServer(0, 4, quoter, powManager)
+
+	served := make(chan error)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	<-server.Ready()
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	server.Drain()
+
+	assert.EqualError(t, server.Readiness(), "server is draining")
+	assert.NoError(t, <-served)
+
+	_, err = net.Dial("tcp", server.getAddr())
+	assert.Error(t, err)
+
+	// the exchange in flight isn't cut short
+	fmt.Fprintln(conn, 42)
+	response, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, quote.String()+"\n", response)
+
+	assert.Eventually(t, func() bool { return server.openConnections() == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSlowClient is returned by reads of a client that sends its request too slowly
+var ErrSlowClient = errors.New("client sends the request too slowly")
+
+// SlowClientPolicy defends against clients that hold a connection by trickling a request.
+// Once the first byte of a request has arrived, the rest of it must follow without long
+// gaps and at a minimum average rate.
+type SlowClientPolicy struct {
+	// IdleGap is the longest allowed pause between bytes of a request
+	IdleGap time.Duration
+	// MinThroughput is the minimum average rate of a request in bytes per second
+	MinThroughput int
+	// ThroughputGrace is the time a request may take before MinThroughput is enforced
+	ThroughputGrace time.Duration
+	// PressureConnections is the number of open connections at which the server is under pressure.
+	// Zero disables pressure handling.
+	PressureConnections int
+	// PressureIdleGap replaces IdleGap while the server is under pressure,
+	// trickling connections that exceed it are closed right away
+	PressureIdleGap time.Duration
+}
+
+func defaultSlowClientPolicy() SlowClientPolicy {
+	return SlowClientPolicy{
+		IdleGap:         time.Second,
+		MinThroughput:   128,
+		ThroughputGrace: time.Second,
+		PressureIdleGap: 200 * time.Millisecond,
+	}
+}
+
+// guardedConn enforces the slow client policy on the reads of a connection.
+// A request starts with its first byte and ends when the session sets the next read deadline.
+type guardedConn struct {
+	net.Conn
+	server *TCPServer
+	policy SlowClientPolicy
+
+	mutex sync.Mutex
+	// deadline is the read deadline of the current phase
+	deadline time.Time
+	// started is when the first byte of the current request arrived, zero while waiting for it
+	started  time.Time
+	lastByte time.Time
+	received int
+}
+
+func (s *TCPServer) guard(conn net.Conn, policy SlowClientPolicy) *guardedConn {
+	return &guardedConn{Conn: conn, server: s, policy: policy}
+}
+
+func (c *guardedConn) SetDeadline(t time.Time) error {
+	c.reset(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *guardedConn) SetReadDeadline(t time.Time) error {
+	c.reset(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *guardedConn) Close() error {
+	c.reset(time.Time{})
+	return c.Conn.Close()
+}
+
+// reset finishes the current request and starts waiting for the next one
+func (c *guardedConn) reset(deadline time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.started.IsZero() {
+		c.server.collectRequestProgress(-1)
+	}
+
+	c.deadline = deadline
+	c.started, c.lastByte, c.received = time.Time{}, time.Time{}, 0
+}
+
+func (c *guardedConn) Read(p []byte) (int, error) {
+	policy := c.policy
+	idleGap := c.server.idleGap(policy)
+
+	c.mutex.Lock()
+	inRequest := !c.started.IsZero()
+	deadline := c.deadline
+	if inRequest {
+		gapDeadline := c.lastByte.Add(idleGap)
+		if deadline.IsZero() || gapDeadline.Before(deadline) {
+			deadline = gapDeadline
+		}
+	}
+	c.mutex.Unlock()
+
+	if inRequest {
+		if err := c.Conn.SetReadDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.Conn.Read(p)
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if n > 0 {
+		if c.started.IsZero() {
+			c.started = now
+			c.server.collectRequestProgress(1)
+		}
+		c.lastByte = now
+		c.received += n
+
+		elapsed := now.Sub(c.started)
+		if policy.MinThroughput > 0 && elapsed > policy.ThroughputGrace &&
+			float64(c.received)/elapsed.Seconds() < float64(policy.MinThroughput) {
+			c.server.collectTrickling()
+			return n, errors.Wrapf(ErrSlowClient, "%d bytes in %s", c.received, elapsed)
+		}
+	}
+
+	if err == nil || c.started.IsZero() {
+		return n, err
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout() && (c.deadline.IsZero() || now.Before(c.deadline)):
+		// the idle gap ran out before the deadline of the phase
+		c.server.collectTrickling()
+		return n, errors.Wrapf(ErrSlowClient, "no data for %s", now.Sub(c.lastByte))
+	case err == io.EOF:
+		c.server.collectHalfOpen()
+	}
+
+	return n, err
+}
+
+// stalled reports whether the connection is in the middle of a request and has sent nothing for longer than gap
+func (c *guardedConn) stalled(gap time.Duration, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return !c.started.IsZero() && now.Sub(c.lastByte) > gap
+}
+
+// underPressure reports whether the number of open connections reached the pressure threshold
+func (s *TCPServer) underPressure(policy SlowClientPolicy) bool {
+	threshold := policy.PressureConnections
+	if threshold <= 0 {
+		return false
+	}
+
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	return len(s.connections) >= threshold
+}
+
+func (s *TCPServer) idleGap(policy SlowClientPolicy) time.Duration {
+	if s.underPressure(policy) {
+		return policy.PressureIdleGap
+	}
+
+	return policy.IdleGap
+}
+
+// shedSlowClients closes the connections that stalled in the middle of a request for longer
+// than the pressure idle gap. It does nothing unless the server is under pressure.
+func (s *TCPServer) shedSlowClients() {
+	policy := s.liveConfig().Settings.SlowClients
+	if !s.underPressure(policy) {
+		return
+	}
+
+	gap := policy.PressureIdleGap
+	now := time.Now()
+
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	for conn := range s.connections {
+		if guarded, ok := conn.(*guardedConn); ok && guarded.stalled(gap, now) {
+			s.logger.Infof("closing stalled connection from %s", remoteAddr(conn))
+			conn.Close()
+			s.collectShed()
+		}
+	}
+}
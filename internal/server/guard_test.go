@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// chunk is a piece of data that arrives at the given offset from the connection start
+type chunk struct {
+	at   time.Duration
+	data string
+}
+
+// faultConn is a net.Conn that delivers scripted chunks of data with delays.
+// It honours read deadlines and reports io.EOF once the script is over.
+type faultConn struct {
+	start  time.Time
+	chunks []chunk
+
+	mutex    sync.Mutex
+	deadline time.Time
+	written  bytes.Buffer
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newFaultConn(chunks ...chunk) *faultConn {
+	return &faultConn{start: time.Now(), chunks: chunks, closed: make(chan struct{})}
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	c.mutex.Lock()
+	if len(c.chunks) == 0 {
+		c.mutex.Unlock()
+		return 0, io.EOF
+	}
+	next := c.chunks[0]
+	deadline := c.deadline
+	c.mutex.Unlock()
+
+	arrival := c.start.Add(next.at)
+	wait := time.Until(arrival)
+	timedOut := false
+	if !deadline.IsZero() && deadline.Before(arrival) {
+		wait, timedOut = time.Until(deadline), true
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+
+	if timedOut {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n := copy(p, next.data)
+	if n < len(next.data) {
+		c.chunks[0].data = next.data[n:]
+	} else {
+		c.chunks = c.chunks[1:]
+	}
+
+	return n, nil
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.written.Write(p)
+}
+
+func (c *faultConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *faultConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *faultConn) output() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.written.String()
+}
+
+func (c *faultConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+}
+
+func (c *faultConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func (c *faultConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *faultConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *faultConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func newGuardTestServer(policy SlowClientPolicy) *TCPServer {
+	return NewTCPServer(0, 4, nil, nil, WithSlowClientPolicy(policy))
+}
+
+func guardConn(s *TCPServer, conn net.Conn) *guardedConn {
+	return s.guard(conn, s.liveConfig().Settings.SlowClients)
+}
+
+func readAll(conn net.Conn) (string, error) {
+	var buf bytes.Buffer
+	p := make([]byte, 16)
+	for {
+		n, err := conn.Read(p)
+		buf.Write(p[:n])
+		if err != nil {
+			return buf.String(), err
+		}
+	}
+}
+
+func TestGuardedConn_IdleGap(t *testing.T) {
+	server := newGuardTestServer(SlowClientPolicy{IdleGap: 100 * time.Millisecond})
+	conn := guardConn(server, newFaultConn(
+		// waiting for the first byte is bounded by the phase deadline only
+		chunk{at: 200 * time.Millisecond, data: "PI"},
+		chunk{at: 500 * time.Millisecond, data: "NG\n"},
+	))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	data, err := readAll(conn)
+	assert.ErrorIs(t, err, ErrSlowClient)
+	assert.Equal(t, "PI", data)
+
+	stats := server.stats()
+	assert.Equal(t, 1, stats.tricklingConnections)
+	assert.Equal(t, 1, stats.pendingRequests)
+
+	conn.Close()
+	assert.Equal(t, 0, server.stats().pendingRequests)
+}
+
+func TestGuardedConn_MinThroughput(t *testing.T) {
+	server := newGuardTestServer(SlowClientPolicy{
+		IdleGap:         100 * time.Millisecond,
+		MinThroughput:   100,
+		ThroughputGrace: 200 * time.Millisecond,
+	})
+
+	chunks := make([]chunk, 0, 10)
+	for i := 0; i < 10; i++ {
+		chunks = append(chunks, chunk{at: time.Duration(i) * 50 * time.Millisecond, data: "a"})
+	}
+	conn := guardConn(server, newFaultConn(chunks...))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	data, err := readAll(conn)
+	assert.ErrorIs(t, err, ErrSlowClient)
+	assert.Less(t, len(data), 10)
+	assert.Equal(t, 1, server.stats().tricklingConnections)
+}
+
+func TestGuardedConn_PhaseTimeout(t *testing.T) {
+	server := newGuardTestServer(SlowClientPolicy{IdleGap: 100 * time.Millisecond})
+	conn := guardConn(server, newFaultConn(chunk{at: 300 * time.Millisecond, data: "PING\n"}))
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	_, err := readAll(conn)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Equal(t, 0, server.stats().tricklingConnections)
+}
+
+func TestGuardedConn_HalfOpen(t *testing.T) {
+	server := newGuardTestServer(SlowClientPolicy{})
+	conn := guardConn(server, newFaultConn(chunk{data: "PI"}))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	data, err := readAll(conn)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "PI", data)
+	assert.Equal(t, 1, server.stats().halfOpenConnections)
+}
+
+func TestTCPServer_ShedSlowClients(t *testing.T) {
+	server := newGuardTestServer(SlowClientPolicy{PressureConnections: 2, PressureIdleGap: 50 * time.Millisecond})
+
+	stalledConn := newFaultConn(chunk{data: "PI"}, chunk{at: time.Hour, data: "NG\n"})
+	stalled := guardConn(server, stalledConn)
+	idleConn := newFaultConn(chunk{at: time.Hour, data: "1\n"})
+	idle := guardConn(server, idleConn)
+
+	server.connections[stalled] = &connState{conn: stalled}
+	server.connections[idle] = &connState{conn: idle}
+
+	stalled.SetReadDeadline(time.Now().Add(time.Minute))
+	p := make([]byte, 16)
+	n, err := stalled.Read(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	time.Sleep(100 * time.Millisecond)
+	server.shedSlowClients()
+
+	// only the connection stalled in the middle of a request is closed
+	assert.True(t, stalledConn.isClosed())
+	assert.False(t, idleConn.isClosed())
+	assert.Equal(t, 1, server.stats().shedConnections)
+}
+
+func TestTCPServer_HandleTricklingConnection(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	powManager.EXPECT().GenerateChallenge(4).Return("123456789", nil)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithSlowClientPolicy(SlowClientPolicy{IdleGap: 100 * time.Millisecond}))
+
+	conn := newFaultConn(chunk{data: "4"}, chunk{at: time.Second, data: "2\n"})
+	server.serveConn(conn, &listener{label: "tcp"})
+
+	assert.Equal(t, "123456789\n"+protocol.ErrCodeTimeout+" "+SlowClientResponse+"\n", conn.output())
+	assert.True(t, conn.isClosed())
+}
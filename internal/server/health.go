@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+)
+
+// Readiness tells why the server can't serve quotes, it's nil when the server is ready:
+// the server isn't draining, the listeners are open and accepting and there are quotes to serve
+func (s *TCPServer) Readiness() error {
+	select {
+	case <-s.ready:
+	default:
+		return errors.New("listeners aren't open yet")
+	}
+
+	s.connMutex.Lock()
+	draining := s.draining
+	s.connMutex.Unlock()
+	if draining {
+		return errors.New("server is draining")
+	}
+
+	if s.acceptStopped.Load() {
+		return errors.New("listeners are closed")
+	}
+
+	for _, l := range s.listeners {
+		if l.down.Load() {
+			return fmt.Errorf("%s listener is down", l.label)
+		}
+	}
+
+	quoter := s.liveConfig().Quotes
+	if quoter == nil {
+		return errors.New("quotes aren't loaded")
+	}
+	if _, err := quoter.GetRandomQuote(quotes.Filter{}); err != nil {
+		if errors.Is(err, quotes.ErrNotFound) {
+			return errors.New("there are no quotes")
+		}
+		return fmt.Errorf("quotes aren't available: %w", err)
+	}
+
+	return nil
+}
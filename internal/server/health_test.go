@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+)
+
+func TestTCPServer_Readiness(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	empty := mocks.NewMockQuoter(c)
+
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quotes.Quote{Text: "Be yourself."}, nil).AnyTimes()
+	empty.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quotes.Quote{}, quotes.ErrNotFound)
+
+	primary, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	secondary, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := NewTCPServer(0, 4, quoter, mocks.NewMockProofOfWorkManager(c),
+		WithNetListener("primary", primary, ListenerPolicy{}),
+		WithNetListener("secondary", secondary, ListenerPolicy{}))
+
+	assert.EqualError(t, server.Readiness(), "listeners aren't open yet")
+
+	go server.ListenAndServe()
+	<-server.Ready()
+
+	assert.NoError(t, server.Readiness())
+
+	// the quotes are swapped for an empty file and back
+	server.Reload(LiveConfig{PowDifficulty: 4, Settings: DefaultSettings(), Quotes: empty})
+	assert.EqualError(t, server.Readiness(), "there are no quotes")
+	server.Reload(LiveConfig{PowDifficulty: 4, Settings: DefaultSettings(), Quotes: quoter})
+
+	secondary.Close()
+	assert.Eventually(t, func() bool {
+		err := server.Readiness()
+		return err != nil && err.Error() == "secondary listener is down"
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = server.Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	assert.EqualError(t, server.Readiness(), "server is draining")
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// ConnInfo describes the connection a hook is called for
+type ConnInfo struct {
+	// ID tells the connections of the process apart
+	ID uint64
+	// Client is the host the reputation of the client is kept by
+	Client string
+	// RemoteAddr is the address of the client, after the PROXY protocol header if there is one
+	RemoteAddr net.Addr
+	// Listener is the label of the listener the connection arrived on
+	Listener string
+	// Policy is the policy of the listener
+	Policy ListenerPolicy
+	// Accepted is when the connection was accepted by the server clock
+	Accepted time.Time
+}
+
+// Hooks are called at the points of the life of a TCP or WebSocket connection, any of them may be nil.
+// A request of the HTTP frontend and a datagram of the UDP mode are taken for connections of their
+// own: they're accepted, get a challenge or redeem one, and are closed once they're answered.
+// A hook that returns an error ends the connection: the code and the message of a *RejectError
+// are sent to the client, other errors are reported as an internal error.
+// The hooks of a connection are called from the goroutine serving it.
+type Hooks struct {
+	// OnAccept is called for a new connection before the greeting challenge
+	OnAccept func(info ConnInfo) error
+	// BeforeChallenge is called before a challenge is issued and may change its difficulty
+	BeforeChallenge func(info ConnInfo, difficulty int) (int, error)
+	// AfterChallenge is called every time a challenge is sent, including the resend
+	// of the greeting challenge in a negotiated protocol mode
+	AfterChallenge func(info ConnInfo, challenge string, difficulty int)
+	// OnSolution is called for every solution before it's verified
+	OnSolution func(info ConnInfo, challenge string, nonce int) error
+	// OnVerification is called with the result of verifying a solution
+	OnVerification func(info ConnInfo, challenge string, nonce int, valid bool)
+	// BeforeQuote is called before a quote is sent and may change it
+	BeforeQuote func(info ConnInfo, quote *quotes.Quote) error
+	// OnClose is called when the connection is closed, after any other hook
+	OnClose func(info ConnInfo)
+}
+
+// RejectError refuses a connection with an error response, see Reject
+type RejectError struct {
+	Code    string
+	Message string
+}
+
+func (e *RejectError) Error() string {
+	return e.Code + " " + e.Message
+}
+
+// Reject returns the error for a hook to end the connection with the error code
+// of the protocol catalog and the message
+func Reject(code, message string) error {
+	return &RejectError{Code: code, Message: message}
+}
+
+// WithHooks adds the hooks to the pipeline. The hooks of a point are called
+// in the order they were added until one of them fails.
+func WithHooks(hooks Hooks) Option {
+	return func(s *TCPServer) {
+		s.hooks = append(s.hooks, hooks)
+	}
+}
+
+// hookChain runs the hooks of every point in order
+type hookChain []Hooks
+
+func (c hookChain) accept(info ConnInfo) error {
+	for _, h := range c {
+		if h.OnAccept != nil {
+			if err := h.OnAccept(info); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c hookChain) beforeChallenge(info ConnInfo, difficulty int) (int, error) {
+	for _, h := range c {
+		if h.BeforeChallenge != nil {
+			var err error
+			if difficulty, err = h.BeforeChallenge(info, difficulty); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return difficulty, nil
+}
+
+func (c hookChain) afterChallenge(info ConnInfo, challenge string, difficulty int) {
+	for _, h := range c {
+		if h.AfterChallenge != nil {
+			h.AfterChallenge(info, challenge, difficulty)
+		}
+	}
+}
+
+func (c hookChain) solution(info ConnInfo, challenge string, nonce int) error {
+	for _, h := range c {
+		if h.OnSolution != nil {
+			if err := h.OnSolution(info, challenge, nonce); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c hookChain) verification(info ConnInfo, challenge string, nonce int, valid bool) {
+	for _, h := range c {
+		if h.OnVerification != nil {
+			h.OnVerification(info, challenge, nonce, valid)
+		}
+	}
+}
+
+func (c hookChain) beforeQuote(info ConnInfo, quote *quotes.Quote) error {
+	for _, h := range c {
+		if h.BeforeQuote != nil {
+			if err := h.BeforeQuote(info, quote); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c hookChain) close(info ConnInfo) {
+	for _, h := range c {
+		if h.OnClose != nil {
+			h.OnClose(info)
+		}
+	}
+}
+
+// requestInfo describes a request of the HTTP frontend or a datagram of the UDP mode to the hooks
+func (s *TCPServer) requestInfo(l *listener, addr net.Addr, client string, received time.Time) ConnInfo {
+	return ConnInfo{
+		ID:         s.connIDs.Add(1),
+		Client:     client,
+		RemoteAddr: addr,
+		Listener:   l.label,
+		Policy:     l.policy,
+		Accepted:   received,
+	}
+}
+
+// banHooks turn away banned clients, except on listeners that skip proof of work.
+// They are the first hooks of every server.
+func (s *TCPServer) banHooks() Hooks {
+	return Hooks{
+		OnAccept: func(info ConnInfo) error {
+			if !info.Policy.SkipPoW && s.reputation.IsBanned(info.Client) {
+				s.metrics.RateLimited(info.Listener)
+				return Reject(protocol.ErrCodeRateLimited, BannedResponse)
+			}
+
+			return nil
+		},
+	}
+}
+
+// rejection returns the response to a failed hook
+func rejection(err error) protocol.Message {
+	var reject *RejectError
+	if errors.As(err, &reject) {
+		return protocol.NewError(reject.Code, reject.Message)
+	}
+
+	return protocol.NewError(protocol.ErrCodeInternal, InternalServerErrorResponse)
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+func TestWithHooks(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	// the hooks raise the difficulty of the challenge one after another
+	powManager.EXPECT().GenerateChallenge(4).Return("challenge", nil)
+	powManager.EXPECT().VerifySolution("challenge", 42).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	var mutex sync.Mutex
+	var events []string
+	record := func(format string, args ...any) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	closed := make(chan struct{})
+
+	l := newPipeListener()
+	server, err := New(
+		WithQuotes(quoter),
+		WithProofOfWork(powManager),
+		WithPowDifficulty(2),
+		WithListenerFactory(func(network, address string) (net.Listener, error) { return l, nil }),
+		WithListener(ListenerConfig{Label: "memory", Network: "tcp", Address: "in-memory"}),
+		WithHooks(Hooks{
+			OnAccept: func(info ConnInfo) error {
+				record("accept %s", info.Listener)
+				return nil
+			},
+			BeforeChallenge: func(info ConnInfo, difficulty int) (int, error) {
+				return difficulty + 1, nil
+			},
+			OnSolution: func(info ConnInfo, challenge string, nonce int) error {
+				record("solution %s %d", challenge, nonce)
+				return nil
+			},
+			BeforeQuote: func(info ConnInfo, quote *quotes.Quote) error {
+				quote.Author = "Anonymous"
+				return nil
+			},
+		}),
+		WithHooks(Hooks{
+			BeforeChallenge: func(info ConnInfo, difficulty int) (int, error) {
+				return difficulty + 1, nil
+			},
+			AfterChallenge: func(info ConnInfo, challenge string, difficulty int) {
+				record("challenge %s %d", challenge, difficulty)
+			},
+			OnVerification: func(info ConnInfo, challenge string, nonce int, valid bool) {
+				record("verified %t", valid)
+			},
+			OnClose: func(info ConnInfo) {
+				record("close")
+				close(closed)
+			},
+		}),
+	)
+	assert.NoError(t, err)
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	<-server.Ready()
+	defer server.CloseListeners()
+
+	conn := l.dial()
+	reader := bufio.NewReader(conn)
+
+	challenge, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "challenge\n", challenge)
+
+	fmt.Fprintln(conn, "42")
+	response, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, quote.Text+" - Anonymous\n", response)
+	conn.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the close hook isn't called")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"accept memory", "challenge challenge 4", "solution challenge 42", "verified true", "close"}, events)
+}
+
+func TestWithHooks_Reject(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	powManager.EXPECT().GenerateChallenge(2).Return("challenge", nil)
+
+	l := newPipeListener()
+	server, err := New(
+		WithQuotes(quoter),
+		WithProofOfWork(powManager),
+		WithPowDifficulty(2),
+		WithListenerFactory(func(network, address string) (net.Listener, error) { return l, nil }),
+		WithListener(ListenerConfig{Label: "memory", Network: "tcp", Address: "in-memory"}),
+		WithHooks(Hooks{
+			OnSolution: func(info ConnInfo, challenge string, nonce int) error {
+				if nonce == 0 {
+					return Reject(protocol.ErrCodeRateLimited, "No free lunch")
+				}
+				return nil
+			},
+		}),
+	)
+	assert.NoError(t, err)
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	<-server.Ready()
+	defer server.CloseListeners()
+
+	conn := l.dial()
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	// the solution is refused before it's verified
+	fmt.Fprintln(conn, "0")
+	response, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeRateLimited+" No free lunch\n", response)
+}
+
+func TestWithHooks_Frontends(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(3).Return("seed:3", nil).Times(2)
+	powManager.EXPECT().VerifySolution("seed:3", 42).Return(true, nil).Times(2)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil).Times(2)
+
+	// the frontends answer from the goroutine of the test
+	var events []string
+	var blocked string
+
+	tcp, err := New(
+		WithQuotes(quoter),
+		WithProofOfWork(powManager),
+		WithPowDifficulty(2),
+		WithHooks(Hooks{
+			OnAccept: func(info ConnInfo) error {
+				if info.Client == blocked {
+					return Reject(protocol.ErrCodeRateLimited, "Go away")
+				}
+				events = append(events, fmt.Sprintf("accept %s %s", info.Listener, info.RemoteAddr))
+				return nil
+			},
+			BeforeChallenge: func(info ConnInfo, difficulty int) (int, error) {
+				return difficulty + 1, nil
+			},
+			AfterChallenge: func(info ConnInfo, challenge string, difficulty int) {
+				events = append(events, fmt.Sprintf("challenge %d", difficulty))
+			},
+			OnSolution: func(info ConnInfo, challenge string, nonce int) error {
+				events = append(events, fmt.Sprintf("solution %d", nonce))
+				return nil
+			},
+			OnVerification: func(info ConnInfo, challenge string, nonce int, valid bool) {
+				events = append(events, fmt.Sprintf("verified %t", valid))
+			},
+			BeforeQuote: func(info ConnInfo, quote *quotes.Quote) error {
+				quote.Author = "Anonymous"
+				return nil
+			},
+			OnClose: func(info ConnInfo) {
+				events = append(events, "close")
+			},
+		}),
+	)
+	assert.NoError(t, err)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	handler := NewHTTPServer(0, tcp, signer).Handler()
+	udp := NewUDPServer(0, tcp, signer)
+
+	// every request is a connection of its own to the hooks
+	_, msg := doHTTP(handler, http.MethodGet, "/challenge", nil)
+	assert.Equal(t, 3, msg.Difficulty)
+
+	status, msg := doHTTP(handler, http.MethodGet, "/quote?"+url.Values{"challenge": {msg.Challenge}, "nonce": {"42"}}.Encode(), nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Anonymous", msg.Quote.Author)
+
+	response := string(udp.handle(pad("QUOTE", 512), udpAddr("192.0.2.1")))
+	signed, _ := strings.CutPrefix(strings.TrimSpace(response), protocol.ChallengePrefix+" ")
+
+	response = string(udp.handle(pad("QUOTE "+signed+" 42", 512), udpAddr("192.0.2.1")))
+	assert.Equal(t, quote.Text+" - Anonymous\n", response)
+
+	assert.Equal(t, []string{
+		"accept http 192.0.2.1:1234", "challenge 3", "close",
+		"accept http 192.0.2.1:1234", "solution 42", "verified true", "close",
+		"accept udp 192.0.2.1:5000", "challenge 3", "close",
+		"accept udp 192.0.2.1:5000", "solution 42", "verified true", "close",
+	}, events)
+
+	// the accept hooks turn clients away on both frontends
+	blocked = "192.0.2.1"
+
+	status, msg = doHTTP(handler, http.MethodGet, "/challenge", nil)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, "Go away", msg.Error)
+
+	response = string(udp.handle(pad("QUOTE", 512), udpAddr("192.0.2.1")))
+	assert.Equal(t, protocol.ErrCodeRateLimited+" Go away\n", response)
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/web"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+const (
+	// ChallengeHeader and NonceHeader carry a solution of GET /quote,
+	// the "challenge" and "nonce" query parameters may be used instead
+	ChallengeHeader = "X-Pow-Challenge"
+	NonceHeader     = "X-Pow-Nonce"
+
+	SolutionRequiredResponse = "Solution is required"
+	InvalidChallengeResponse = "Invalid challenge"
+	ReplayedResponse         = "Challenge has already been used"
+	MethodNotAllowedResponse = "Method not allowed"
+)
+
+// HTTPServer serves proof-of-work gated quotes over HTTP with JSON responses.
+// It shares the quotes, the proof-of-work manager, the difficulty, the reputation
+// and the metrics of the TCP server. HTTP is stateless, so challenges are signed
+// and carry their own expiry, see the challenge package. The server uses TLS
+// when the TCP server does.
+type HTTPServer struct {
+	tcp    *TCPServer
+	signer *challenge.Signer
+	server *http.Server
+}
+
+func NewHTTPServer(port int, tcp *TCPServer, signer *challenge.Signer) *HTTPServer {
+	h := &HTTPServer{tcp: tcp, signer: signer}
+	timeouts := tcp.liveConfig().Settings.Timeouts
+
+	h.server = &http.Server{
+		Addr:              ":" + strconv.Itoa(port),
+		Handler:           h.Handler(),
+		ReadHeaderTimeout: timeouts.Handshake,
+		WriteTimeout:      timeouts.Write,
+		TLSConfig:         tcp.tlsConfig,
+	}
+
+	return h
+}
+
+// Handler routes GET /challenge, GET /quote, the WebSocket gateway at /ws and the example page
+func (h *HTTPServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/challenge", h.getOnly(h.handleChallenge))
+	mux.HandleFunc("/quote", h.getOnly(h.handleQuote))
+	mux.HandleFunc("/ws", h.getOnly(h.handleWebSocket))
+	mux.Handle("/", http.FileServer(http.FS(web.Files())))
+
+	return mux
+}
+
+func (h *HTTPServer) ListenAndServe() error {
+	l, err := h.tcp.listen("tcp", h.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	return h.Serve(l)
+}
+
+// Serve serves the requests of the listener, e.g. one passed by a parent process
+func (h *HTTPServer) Serve(l net.Listener) error {
+	h.tcp.logger.Infof("Starting HTTP server at %s", l.Addr())
+
+	// clients behind a balancer are recovered like those of the TCP server
+	l = h.tcp.proxyListener(l)
+
+	var err error
+	if h.server.TLSConfig != nil {
+		// the certificates come from the shared TLS config
+		err = h.server.ServeTLS(l, "", "")
+	} else {
+		err = h.server.Serve(l)
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (h *HTTPServer) Shutdown(ctx context.Context) error {
+	return h.server.Shutdown(ctx)
+}
+
+func (h *HTTPServer) getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, protocol.NewError(protocol.ErrCodeUnknownCommand, MethodNotAllowedResponse))
+			return
+		}
+
+		h.tcp.logger.Infof("received %s request from %s", r.URL.Path, r.RemoteAddr)
+
+		handler(w, r)
+	}
+}
+
+// accept runs the accept hooks, which turn away banned clients, for a request.
+// The hooks see the request as a connection of its own, the caller closes it with the close hooks.
+func (h *HTTPServer) accept(w http.ResponseWriter, r *http.Request, info ConnInfo) bool {
+	if err := h.tcp.hooks.accept(info); err != nil {
+		h.tcp.logger.Infof("request from %s is rejected: %s", r.RemoteAddr, err)
+		h.reject(w, err)
+		return false
+	}
+
+	return true
+}
+
+// reject answers a request a hook refused
+func (h *HTTPServer) reject(w http.ResponseWriter, err error) {
+	msg := rejection(err)
+
+	status := http.StatusForbidden
+	switch msg.Code {
+	case protocol.ErrCodeRateLimited:
+		status = http.StatusTooManyRequests
+	case protocol.ErrCodeInternal:
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, msg)
+}
+
+func (h *HTTPServer) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	info := h.tcp.requestInfo(httpListener, requestAddr(r), requestHost(r), h.tcp.now())
+	defer h.tcp.hooks.close(info)
+
+	if !h.accept(w, r, info) {
+		return
+	}
+
+	config := h.tcp.liveConfig()
+	difficulty, err := h.tcp.hooks.beforeChallenge(info, config.PowDifficulty)
+	if err != nil {
+		h.reject(w, err)
+		return
+	}
+
+	plain, err := h.tcp.powManager.GenerateChallenge(difficulty)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, protocol.NewError(protocol.ErrCodeInternal, InternalServerErrorResponse))
+		return
+	}
+	h.tcp.metrics.ChallengeIssued(httpListener.label)
+
+	signed := h.signer.SignWithTTL(plain, config.Settings.Timeouts.solveWindow(difficulty))
+	writeJSON(w, http.StatusOK, protocol.Message{
+		Type:       protocol.TypeChallenge,
+		Challenge:  signed,
+		Difficulty: difficulty,
+	})
+	h.tcp.hooks.afterChallenge(info, signed, difficulty)
+}
+
+func (h *HTTPServer) handleQuote(w http.ResponseWriter, r *http.Request) {
+	startTime := h.tcp.now()
+	client := requestHost(r)
+
+	info := h.tcp.requestInfo(httpListener, requestAddr(r), client, startTime)
+	defer h.tcp.hooks.close(info)
+
+	if !h.accept(w, r, info) {
+		return
+	}
+
+	signed, nonceValue := r.Header.Get(ChallengeHeader), r.Header.Get(NonceHeader)
+	if signed == "" {
+		signed, nonceValue = r.URL.Query().Get("challenge"), r.URL.Query().Get("nonce")
+	}
+
+	if signed == "" || nonceValue == "" {
+		writeJSON(w, http.StatusUnauthorized, protocol.NewError(protocol.ErrCodeBadFormat, SolutionRequiredResponse))
+		return
+	}
+
+	nonce, err := strconv.Atoi(nonceValue)
+	if err != nil {
+		h.recordFailure(client, rejectedMalformed)
+		writeJSON(w, http.StatusBadRequest, protocol.NewError(protocol.ErrCodeBadFormat, InvalidSolutionResponse))
+		return
+	}
+
+	if err := h.tcp.hooks.solution(info, signed, nonce); err != nil {
+		h.reject(w, err)
+		return
+	}
+
+	// like in the UDP mode, failures of expired or forged challenges are not held against the client
+	plain, _, err := h.signer.Verify(signed)
+	switch {
+	case errors.Is(err, challenge.ErrExpired):
+		h.tcp.metrics.SolutionRejected(httpListener.label, rejectedExpired)
+		writeJSON(w, http.StatusUnauthorized, protocol.NewError(protocol.ErrCodeExpired, ExpiredResponse))
+		return
+	case err != nil:
+		h.tcp.metrics.SolutionRejected(httpListener.label, rejectedForged)
+		writeJSON(w, http.StatusBadRequest, protocol.NewError(protocol.ErrCodeBadFormat, InvalidChallengeResponse))
+		return
+	}
+
+	isValid, err := h.tcp.powManager.VerifySolution(plain, nonce)
+	h.tcp.hooks.verification(info, signed, nonce, err == nil && isValid)
+	if err != nil || !isValid {
+		h.recordFailure(client, rejectedWrong)
+		writeJSON(w, http.StatusUnauthorized, protocol.NewError(protocol.ErrCodeWrongSolution, IncorrectSolutionResonse))
+		return
+	}
+
+	// pick the quote before redeeming the challenge so that a filter without matches doesn't cost anything
+	filter := quotes.Filter{Author: r.URL.Query().Get("author"), Category: r.URL.Query().Get("category")}
+	quote, err := h.tcp.liveConfig().Quotes.GetRandomQuote(filter)
+	if err == quotes.ErrNotFound {
+		writeJSON(w, http.StatusNotFound, protocol.NewError(protocol.ErrCodeNotFound, QuoteNotFoundResponse))
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, protocol.NewError(protocol.ErrCodeInternal, InternalServerErrorResponse))
+		return
+	}
+
+	if err := h.tcp.hooks.beforeQuote(info, &quote); err != nil {
+		h.reject(w, err)
+		return
+	}
+
+	if err := h.signer.Redeem(signed); errors.Is(err, challenge.ErrReplayed) {
+		h.tcp.metrics.SolutionRejected(httpListener.label, rejectedReplayed)
+		writeJSON(w, http.StatusUnauthorized, protocol.NewError(protocol.ErrCodeExpired, ReplayedResponse))
+		return
+	} else if err != nil {
+		h.tcp.metrics.SolutionRejected(httpListener.label, rejectedExpired)
+		writeJSON(w, http.StatusUnauthorized, protocol.NewError(protocol.ErrCodeExpired, ExpiredResponse))
+		return
+	}
+
+	h.tcp.reputation.RecordSuccess(client)
+	h.tcp.metrics.SolutionAccepted(httpListener.label)
+
+	writeJSON(w, http.StatusOK, protocol.Message{
+		Type: protocol.TypeQuote,
+		Quote: &protocol.Quote{
+			ID:     quote.ID,
+			Text:   quote.Text,
+			Author: quote.Author,
+			Tags:   quote.Tags,
+		},
+	})
+
+	h.tcp.metrics.QuoteServed(httpListener.label)
+	h.tcp.collectMetrics(httpListener.label, startTime)
+}
+
+func (h *HTTPServer) recordFailure(client, reason string) {
+	h.tcp.collectFailedAttempt(httpListener.label, reason)
+	h.tcp.reputation.RecordFailure(client)
+}
+
+func writeJSON(w http.ResponseWriter, status int, msg protocol.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// requestHost returns the host part of the request's remote address
+func requestHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// requestAddr returns the remote address of the request, nil if it isn't an IP address and port
+func requestAddr(r *http.Request) net.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+
+	return net.TCPAddrFromAddrPort(addrPort)
+}
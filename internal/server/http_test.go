@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+func doHTTP(handler http.Handler, method, target string, header http.Header) (int, protocol.Message) {
+	r := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var msg protocol.Message
+	json.Unmarshal(w.Body.Bytes(), &msg)
+
+	return w.Code, msg
+}
+
+func TestHTTPServer(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{ID: 1, Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("seed:4", nil)
+	powManager.EXPECT().VerifySolution("seed:4", 7).Return(false, nil)
+	powManager.EXPECT().VerifySolution("seed:4", 42).Return(true, nil).Times(3)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{Author: "Plato"}).Return(quotes.Quote{}, quotes.ErrNotFound)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil).Times(2)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, quoter, powManager)
+	handler := NewHTTPServer(0, tcp, signer).Handler()
+
+	status, msg := doHTTP(handler, http.MethodGet, "/challenge", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, protocol.TypeChallenge, msg.Type)
+	assert.Equal(t, 4, msg.Difficulty)
+	signed := msg.Challenge
+
+	query := func(nonce string, extra ...string) string {
+		values := url.Values{"challenge": {signed}, "nonce": {nonce}}
+		for i := 0; i+1 < len(extra); i += 2 {
+			values.Set(extra[i], extra[i+1])
+		}
+		return "/quote?" + values.Encode()
+	}
+
+	status, msg = doHTTP(handler, http.MethodPost, "/challenge", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+	assert.Equal(t, protocol.ErrCodeUnknownCommand, msg.Code)
+
+	status, msg = doHTTP(handler, http.MethodGet, "/quote", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, protocol.ErrCodeBadFormat, msg.Code)
+
+	status, msg = doHTTP(handler, http.MethodGet, query("4two"), nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, protocol.ErrCodeBadFormat, msg.Code)
+
+	status, msg = doHTTP(handler, http.MethodGet, "/quote?challenge=seed:4:1:00&nonce=42", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, protocol.ErrCodeBadFormat, msg.Code)
+
+	status, msg = doHTTP(handler, http.MethodGet, query("7"), nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, protocol.ErrCodeWrongSolution, msg.Code)
+
+	// a filter without matches doesn't use up the challenge
+	status, msg = doHTTP(handler, http.MethodGet, query("42", "author", "Plato"), nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, protocol.ErrCodeNotFound, msg.Code)
+
+	// the solution is accepted in headers as well
+	status, msg = doHTTP(handler, http.MethodGet, "/quote", http.Header{
+		ChallengeHeader: {signed},
+		NonceHeader:     {"42"},
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, protocol.TypeQuote, msg.Type)
+	assert.Equal(t, &protocol.Quote{ID: 1, Text: quote.Text, Author: quote.Author}, msg.Quote)
+
+	status, msg = doHTTP(handler, http.MethodGet, query("42"), nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, protocol.ErrCodeExpired, msg.Code)
+	assert.Equal(t, ReplayedResponse, msg.Error)
+
+	stats := tcp.stats()
+	assert.Equal(t, 1, stats.requestsHandled)
+	assert.Equal(t, 2, stats.failedAttempts)
+}
+
+func TestHTTPServer_RejectedChallenges(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	// expired or forged challenges don't count against the client
+	reputation := mocks.NewMockReputationTracker(c)
+	reputation.EXPECT().IsBanned("192.0.2.1").Return(false).AnyTimes()
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, mocks.NewMockQuoter(c), mocks.NewMockProofOfWorkManager(c), WithReputation(reputation))
+	handler := NewHTTPServer(0, tcp, signer).Handler()
+
+	expired := url.Values{"challenge": {signer.SignWithTTL("seed:4", -time.Minute)}, "nonce": {"42"}}
+	status, msg := doHTTP(handler, http.MethodGet, "/quote?"+expired.Encode(), nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, protocol.ErrCodeExpired, msg.Code)
+
+	status, msg = doHTTP(handler, http.MethodGet, "/quote?challenge=seed:4:1:00&nonce=42", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, protocol.ErrCodeBadFormat, msg.Code)
+
+	assert.Equal(t, 0, tcp.stats().failedAttempts)
+}
+
+func TestHTTPServer_Banned(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	reputation := mocks.NewMockReputationTracker(c)
+	reputation.EXPECT().IsBanned("192.0.2.1").Return(true)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, mocks.NewMockQuoter(c), mocks.NewMockProofOfWorkManager(c), WithReputation(reputation))
+
+	status, msg := doHTTP(NewHTTPServer(0, tcp, signer).Handler(), http.MethodGet, "/challenge", nil)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, protocol.ErrCodeRateLimited, msg.Code)
+}
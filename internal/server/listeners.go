@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ListenerPolicy holds the rules for the connections accepted by a listener
+type ListenerPolicy struct {
+	// SkipPoW serves quotes without proof of work, e.g. to sidecars on a local Unix socket.
+	// The greeting challenge is still sent, with zero difficulty, so that any nonce solves it
+	// and existing clients keep working; command sessions aren't charged at all.
+	SkipPoW bool
+}
+
+// ListenerConfig describes a listener the server opens itself
+type ListenerConfig struct {
+	// Label names the listener in logs and metrics, defaults to the network
+	Label string
+	// Network is "tcp", "tcp4", "tcp6" or "unix"
+	Network string
+	// Address is a host:port bind address or a socket path
+	Address string
+	// Mode is the file mode of a Unix socket, zero keeps the umask default
+	Mode   os.FileMode
+	Policy ListenerPolicy
+}
+
+// listener is an open listener with its label and policy
+type listener struct {
+	net.Listener
+	label  string
+	policy ListenerPolicy
+	// raw is the listener before it's wrapped, e.g. to read PROXY protocol headers
+	raw net.Listener
+	// down is set once the listener stops accepting
+	down atomic.Bool
+}
+
+// The gateways that hand their connections and requests over to the server
+var (
+	httpListener      = &listener{label: "http"}
+	webSocketListener = &listener{label: "websocket"}
+	udpListener       = &listener{label: "udp"}
+)
+
+// encrypted tells whether connections of the listener get TLS when it's configured.
+// Unix sockets are local and stay plaintext.
+func (l *listener) encrypted() bool {
+	return l.Listener != nil && strings.HasPrefix(l.Addr().Network(), "tcp")
+}
+
+// proxied tells whether connections of the listener may come through a load balancer,
+// so their PROXY protocol headers are read when the protocol is enabled, with or without TLS.
+// Unix sockets are reached locally and never carry a header.
+func (l *listener) proxied() bool {
+	return l.Listener != nil && l.Addr().Network() != "unix"
+}
+
+// WithListener adds a listener to open in ListenAndServe.
+// Without listeners the server listens on TCP on all interfaces at its port.
+func WithListener(config ListenerConfig) Option {
+	return func(s *TCPServer) {
+		s.listenerConfigs = append(s.listenerConfigs, config)
+	}
+}
+
+// WithNetListener serves connections of a listener opened by the caller.
+// The server closes it on shutdown.
+func WithNetListener(label string, l net.Listener, policy ListenerPolicy) Option {
+	return func(s *TCPServer) {
+		s.listeners = append(s.listeners, &listener{Listener: l, label: label, policy: policy})
+	}
+}
+
+// listen opens the listener described by the config with the factory
+func (c ListenerConfig) listen(factory ListenerFactory) (*listener, error) {
+	label := c.Label
+	if label == "" {
+		label = c.Network
+	}
+
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6":
+		l, err := factory(c.Network, c.Address)
+		if err != nil {
+			return nil, err
+		}
+		return &listener{Listener: l, label: label, policy: c.Policy}, nil
+	case "unix":
+		// a socket file left by a crashed process would make the address busy
+		if info, err := os.Stat(c.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(c.Address)
+		}
+
+		l, err := factory("unix", c.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.Mode != 0 {
+			if err := os.Chmod(c.Address, c.Mode); err != nil {
+				l.Close()
+				return nil, errors.Wrapf(err, "failed to set mode of %s", c.Address)
+			}
+		}
+		return &listener{Listener: l, label: label, policy: c.Policy}, nil
+	default:
+		return nil, fmt.Errorf("unsupported listener network %q", c.Network)
+	}
+}
+
+// ParseListenerConfig parses a listener spec of the form
+// [label=]network://address[?mode=0660&pow=off], e.g.
+// "public=tcp://:9000", "tcp6://[::1]:9000" or "sidecar=unix:///run/quotes.sock?mode=0660&pow=off"
+func ParseListenerConfig(spec string) (ListenerConfig, error) {
+	var config ListenerConfig
+
+	if label, rest, ok := strings.Cut(spec, "="); ok && !strings.Contains(label, "://") {
+		config.Label, spec = label, rest
+	}
+
+	network, address, ok := strings.Cut(spec, "://")
+	if !ok {
+		return config, fmt.Errorf("listener %q: expected network://address", spec)
+	}
+	config.Network = network
+
+	address, query, _ := strings.Cut(address, "?")
+	if address == "" {
+		return config, fmt.Errorf("listener %q: empty address", spec)
+	}
+	config.Address = address
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return config, errors.Wrapf(err, "listener %q", spec)
+	}
+
+	for key := range params {
+		value := params.Get(key)
+		switch key {
+		case "mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || network != "unix" {
+				return config, fmt.Errorf("listener %q: mode must be an octal file mode of a unix socket", spec)
+			}
+			config.Mode = os.FileMode(mode)
+		case "pow":
+			switch value {
+			case "on":
+			case "off":
+				config.Policy.SkipPoW = true
+			default:
+				return config, fmt.Errorf("listener %q: pow must be on or off", spec)
+			}
+		default:
+			return config, fmt.Errorf("listener %q: unknown parameter %q", spec, key)
+		}
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return config, fmt.Errorf("listener %q: unsupported network %q", spec, network)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+)
+
+func TestTCPServer_Listeners(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("public", nil).Times(2)
+	powManager.EXPECT().VerifySolution("public", 42).Return(true, nil).Times(2)
+	// the sidecar gets a challenge that any nonce solves
+	powManager.EXPECT().GenerateChallenge(0).Return("sidecar", nil)
+	powManager.EXPECT().VerifySolution("sidecar", 0).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil).Times(3)
+
+	passed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	socket := filepath.Join(t.TempDir(), "quotes.sock")
+
+	server := NewTCPServer(0, 4, quoter, powManager,
+		WithListener(ListenerConfig{Label: "public", Network: "tcp4", Address: "127.0.0.1:0"}),
+		WithListener(ListenerConfig{Label: "sidecar", Network: "unix", Address: socket, Mode: 0600, Policy: ListenerPolicy{SkipPoW: true}}),
+		WithNetListener("passed", passed, ListenerPolicy{}),
+	)
+
+	assert.Nil(t, server.Addrs())
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	<-server.Ready()
+
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	exchange := func(network, addr, expectedChallenge string, nonce int) {
+		conn, err := net.Dial(network, addr)
+		assert.NoError(t, err)
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		challenge, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, expectedChallenge+"\n", challenge)
+
+		fmt.Fprintln(conn, nonce)
+
+		response, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, quote.String()+"\n", response)
+	}
+
+	addrs := server.Addrs()
+	assert.Equal(t, socket, addrs["sidecar"].String())
+
+	exchange("tcp", addrs["public"].String(), "public", 42)
+	exchange("tcp", addrs["passed"].String(), "public", 42)
+	exchange("unix", socket, "sidecar", 0)
+
+	server.Shutdown(context.Background())
+
+	stats := server.stats()
+	assert.Equal(t, 3, stats.requestsHandled)
+	for _, label := range []string{"public", "passed", "sidecar"} {
+		assert.Equal(t, listenerStats{connections: 1, requestsHandled: 1}, stats.listeners[label], label)
+	}
+
+	// the socket file is removed with the listener
+	time.Sleep(100 * time.Millisecond)
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseListenerConfig(t *testing.T) {
+	tests := []struct {
+		spec       string
+		config     ListenerConfig
+		shouldFail bool
+	}{
+		{spec: "tcp://:9000", config: ListenerConfig{Network: "tcp", Address: ":9000"}},
+		{spec: "public=tcp6://[::1]:9000", config: ListenerConfig{Label: "public", Network: "tcp6", Address: "[::1]:9000"}},
+		{
+			spec:   "sidecar=unix:///run/quotes.sock?mode=0660&pow=off",
+			config: ListenerConfig{Label: "sidecar", Network: "unix", Address: "/run/quotes.sock", Mode: 0660, Policy: ListenerPolicy{SkipPoW: true}},
+		},
+		{spec: ":9000", shouldFail: true},
+		{spec: "udp://:9000", shouldFail: true},
+		{spec: "tcp://", shouldFail: true},
+		{spec: "tcp://:9000?mode=0660", shouldFail: true},
+		{spec: "unix:///run/quotes.sock?mode=rw", shouldFail: true},
+		{spec: "unix:///run/quotes.sock?pow=maybe", shouldFail: true},
+		{spec: "unix:///run/quotes.sock?owner=root", shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			config, err := ParseListenerConfig(tc.spec)
+			if tc.shouldFail {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.config, config)
+		})
+	}
+}
+
+func TestTCPServer_Files(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "quotes.sock")
+
+	server := NewTCPServer(0, 4, nil, nil,
+		WithListener(ListenerConfig{Label: "public", Network: "tcp", Address: "127.0.0.1:0"}),
+		WithListener(ListenerConfig{Label: "sidecar", Network: "unix", Address: socket}),
+	)
+
+	_, err := server.Files()
+	assert.Error(t, err)
+
+	served := make(chan error)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	<-server.Ready()
+
+	files, err := server.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// the duplicate keeps listening for the new process
+	l, err := net.FileListener(files["public"])
+	assert.NoError(t, err)
+
+	defer l.Close()
+
+	server.CloseListeners()
+	assert.NoError(t, <-served)
+
+	_, err = os.Stat(socket)
+	assert.NoError(t, err)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	conn.Close()
+
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+func TestListener_Proxied(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer tcp.Close()
+
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "quotes.sock"))
+	assert.NoError(t, err)
+
+	defer unix.Close()
+
+	// plain TCP listeners read the headers too, whether TLS is configured doesn't matter
+	assert.True(t, (&listener{Listener: tcp}).proxied())
+	assert.False(t, (&listener{Listener: unix}).proxied())
+	assert.False(t, httpListener.proxied())
+}
@@ -6,8 +6,10 @@ package mocks
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	quotes "github.com/zhashkevych/quotes-server/internal/quotes"
 )
 
 // MockQuoter is a mock of Quoter interface.
@@ -33,18 +35,33 @@ func (m *MockQuoter) EXPECT() *MockQuoterMockRecorder {
 	return m.recorder
 }
 
-// GetRandomQuote mocks base method.
-func (m *MockQuoter) GetRandomQuote() string {
+// Categories mocks base method.
+func (m *MockQuoter) Categories() []string {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetRandomQuote")
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Categories")
+	ret0, _ := ret[0].([]string)
 	return ret0
 }
 
+// Categories indicates an expected call of Categories.
+func (mr *MockQuoterMockRecorder) Categories() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Categories", reflect.TypeOf((*MockQuoter)(nil).Categories))
+}
+
+// GetRandomQuote mocks base method.
+func (m *MockQuoter) GetRandomQuote(filter quotes.Filter) (quotes.Quote, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRandomQuote", filter)
+	ret0, _ := ret[0].(quotes.Quote)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // GetRandomQuote indicates an expected call of GetRandomQuote.
-func (mr *MockQuoterMockRecorder) GetRandomQuote() *gomock.Call {
+func (mr *MockQuoterMockRecorder) GetRandomQuote(filter interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRandomQuote", reflect.TypeOf((*MockQuoter)(nil).GetRandomQuote))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRandomQuote", reflect.TypeOf((*MockQuoter)(nil).GetRandomQuote), filter)
 }
 
 // MockProofOfWorkManager is a mock of ProofOfWorkManager interface.
@@ -114,3 +131,267 @@ func (mr *MockProofOfWorkManagerMockRecorder) VerifySolution(challenge, nonce in
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifySolution", reflect.TypeOf((*MockProofOfWorkManager)(nil).VerifySolution), challenge, nonce)
 }
+
+// MockReputationTracker is a mock of ReputationTracker interface.
+type MockReputationTracker struct {
+	ctrl     *gomock.Controller
+	recorder *MockReputationTrackerMockRecorder
+}
+
+// MockReputationTrackerMockRecorder is the mock recorder for MockReputationTracker.
+type MockReputationTrackerMockRecorder struct {
+	mock *MockReputationTracker
+}
+
+// NewMockReputationTracker creates a new mock instance.
+func NewMockReputationTracker(ctrl *gomock.Controller) *MockReputationTracker {
+	mock := &MockReputationTracker{ctrl: ctrl}
+	mock.recorder = &MockReputationTrackerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReputationTracker) EXPECT() *MockReputationTrackerMockRecorder {
+	return m.recorder
+}
+
+// IsBanned mocks base method.
+func (m *MockReputationTracker) IsBanned(client string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsBanned", client)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsBanned indicates an expected call of IsBanned.
+func (mr *MockReputationTrackerMockRecorder) IsBanned(client interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsBanned", reflect.TypeOf((*MockReputationTracker)(nil).IsBanned), client)
+}
+
+// RecordFailure mocks base method.
+func (m *MockReputationTracker) RecordFailure(client string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordFailure", client)
+}
+
+// RecordFailure indicates an expected call of RecordFailure.
+func (mr *MockReputationTrackerMockRecorder) RecordFailure(client interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordFailure", reflect.TypeOf((*MockReputationTracker)(nil).RecordFailure), client)
+}
+
+// RecordSuccess mocks base method.
+func (m *MockReputationTracker) RecordSuccess(client string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordSuccess", client)
+}
+
+// RecordSuccess indicates an expected call of RecordSuccess.
+func (mr *MockReputationTrackerMockRecorder) RecordSuccess(client interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordSuccess", reflect.TypeOf((*MockReputationTracker)(nil).RecordSuccess), client)
+}
+
+// MockMetricsSink is a mock of MetricsSink interface.
+type MockMetricsSink struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsSinkMockRecorder
+}
+
+// MockMetricsSinkMockRecorder is the mock recorder for MockMetricsSink.
+type MockMetricsSinkMockRecorder struct {
+	mock *MockMetricsSink
+}
+
+// NewMockMetricsSink creates a new mock instance.
+func NewMockMetricsSink(ctrl *gomock.Controller) *MockMetricsSink {
+	mock := &MockMetricsSink{ctrl: ctrl}
+	mock.recorder = &MockMetricsSinkMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricsSink) EXPECT() *MockMetricsSinkMockRecorder {
+	return m.recorder
+}
+
+// ChallengeIssued mocks base method.
+func (m *MockMetricsSink) ChallengeIssued(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ChallengeIssued", listener)
+}
+
+// ChallengeIssued indicates an expected call of ChallengeIssued.
+func (mr *MockMetricsSinkMockRecorder) ChallengeIssued(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChallengeIssued", reflect.TypeOf((*MockMetricsSink)(nil).ChallengeIssued), listener)
+}
+
+// ConnectionAccepted mocks base method.
+func (m *MockMetricsSink) ConnectionAccepted(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ConnectionAccepted", listener)
+}
+
+// ConnectionAccepted indicates an expected call of ConnectionAccepted.
+func (mr *MockMetricsSinkMockRecorder) ConnectionAccepted(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionAccepted", reflect.TypeOf((*MockMetricsSink)(nil).ConnectionAccepted), listener)
+}
+
+// ConnectionClosed mocks base method.
+func (m *MockMetricsSink) ConnectionClosed(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ConnectionClosed", listener)
+}
+
+// ConnectionClosed indicates an expected call of ConnectionClosed.
+func (mr *MockMetricsSinkMockRecorder) ConnectionClosed(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionClosed", reflect.TypeOf((*MockMetricsSink)(nil).ConnectionClosed), listener)
+}
+
+// ConnectionDropped mocks base method.
+func (m *MockMetricsSink) ConnectionDropped(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ConnectionDropped", listener)
+}
+
+// ConnectionDropped indicates an expected call of ConnectionDropped.
+func (mr *MockMetricsSinkMockRecorder) ConnectionDropped(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionDropped", reflect.TypeOf((*MockMetricsSink)(nil).ConnectionDropped), listener)
+}
+
+// PhaseDuration mocks base method.
+func (m *MockMetricsSink) PhaseDuration(phase string, duration time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "PhaseDuration", phase, duration)
+}
+
+// PhaseDuration indicates an expected call of PhaseDuration.
+func (mr *MockMetricsSinkMockRecorder) PhaseDuration(phase, duration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PhaseDuration", reflect.TypeOf((*MockMetricsSink)(nil).PhaseDuration), phase, duration)
+}
+
+// ProxyRejected mocks base method.
+func (m *MockMetricsSink) ProxyRejected() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ProxyRejected")
+}
+
+// ProxyRejected indicates an expected call of ProxyRejected.
+func (mr *MockMetricsSinkMockRecorder) ProxyRejected() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProxyRejected", reflect.TypeOf((*MockMetricsSink)(nil).ProxyRejected))
+}
+
+// QueueDepth mocks base method.
+func (m *MockMetricsSink) QueueDepth(depth int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "QueueDepth", depth)
+}
+
+// QueueDepth indicates an expected call of QueueDepth.
+func (mr *MockMetricsSinkMockRecorder) QueueDepth(depth interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueDepth", reflect.TypeOf((*MockMetricsSink)(nil).QueueDepth), depth)
+}
+
+// QuoteServed mocks base method.
+func (m *MockMetricsSink) QuoteServed(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "QuoteServed", listener)
+}
+
+// QuoteServed indicates an expected call of QuoteServed.
+func (mr *MockMetricsSinkMockRecorder) QuoteServed(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QuoteServed", reflect.TypeOf((*MockMetricsSink)(nil).QuoteServed), listener)
+}
+
+// RateLimited mocks base method.
+func (m *MockMetricsSink) RateLimited(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RateLimited", listener)
+}
+
+// RateLimited indicates an expected call of RateLimited.
+func (mr *MockMetricsSinkMockRecorder) RateLimited(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RateLimited", reflect.TypeOf((*MockMetricsSink)(nil).RateLimited), listener)
+}
+
+// RequestHandled mocks base method.
+func (m *MockMetricsSink) RequestHandled(listener string, responseTime time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RequestHandled", listener, responseTime)
+}
+
+// RequestHandled indicates an expected call of RequestHandled.
+func (mr *MockMetricsSinkMockRecorder) RequestHandled(listener, responseTime interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestHandled", reflect.TypeOf((*MockMetricsSink)(nil).RequestHandled), listener, responseTime)
+}
+
+// RequestsInProgress mocks base method.
+func (m *MockMetricsSink) RequestsInProgress(delta int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RequestsInProgress", delta)
+}
+
+// RequestsInProgress indicates an expected call of RequestsInProgress.
+func (mr *MockMetricsSinkMockRecorder) RequestsInProgress(delta interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestsInProgress", reflect.TypeOf((*MockMetricsSink)(nil).RequestsInProgress), delta)
+}
+
+// SlowClient mocks base method.
+func (m *MockMetricsSink) SlowClient(reason string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SlowClient", reason)
+}
+
+// SlowClient indicates an expected call of SlowClient.
+func (mr *MockMetricsSinkMockRecorder) SlowClient(reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SlowClient", reflect.TypeOf((*MockMetricsSink)(nil).SlowClient), reason)
+}
+
+// SolutionAccepted mocks base method.
+func (m *MockMetricsSink) SolutionAccepted(listener string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SolutionAccepted", listener)
+}
+
+// SolutionAccepted indicates an expected call of SolutionAccepted.
+func (mr *MockMetricsSinkMockRecorder) SolutionAccepted(listener interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SolutionAccepted", reflect.TypeOf((*MockMetricsSink)(nil).SolutionAccepted), listener)
+}
+
+// SolutionRejected mocks base method.
+func (m *MockMetricsSink) SolutionRejected(listener, reason string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SolutionRejected", listener, reason)
+}
+
+// SolutionRejected indicates an expected call of SolutionRejected.
+func (mr *MockMetricsSinkMockRecorder) SolutionRejected(listener, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SolutionRejected", reflect.TypeOf((*MockMetricsSink)(nil).SolutionRejected), listener, reason)
+}
+
+// Timeout mocks base method.
+func (m *MockMetricsSink) Timeout(phase string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Timeout", phase)
+}
+
+// Timeout indicates an expected call of Timeout.
+func (mr *MockMetricsSinkMockRecorder) Timeout(phase interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Timeout", reflect.TypeOf((*MockMetricsSink)(nil).Timeout), phase)
+}
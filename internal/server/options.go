@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/tls"
+	"math"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Option configures optional TCPServer behaviour
+type Option func(*TCPServer)
+
+// Settings hold the tunable parts of the server behaviour
+type Settings struct {
+	// KeepAlive allows clients to switch a connection into a command session
+	// instead of receiving a single quote
+	KeepAlive bool
+	// PowGate selects what a solved challenge pays for in a command session
+	PowGate PowGate
+	// CreditsPerSolution is the number of quotes a solved challenge buys with GatePerCredit
+	CreditsPerSolution int
+	// SolutionAttempts is the number of times a client may try to solve the same challenge
+	// before the connection is closed
+	SolutionAttempts int
+	// Timeouts are the deadlines of the protocol phases
+	Timeouts Timeouts
+	// SlowClients limits how slowly a client may send a request
+	SlowClients SlowClientPolicy
+	// MaxRequestSize bounds a request line and a datagram in bytes
+	MaxRequestSize int
+}
+
+// Timeouts hold the deadlines of the protocol phases.
+// The solve window grows with the expected work for the challenge difficulty:
+// SolveBase + 16^difficulty / SolveHashRate, capped by SolveMax.
+type Timeouts struct {
+	// Handshake bounds waiting for a request that is not a solution:
+	// protocol negotiation and session commands
+	Handshake time.Duration
+	// SolveBase is the solve window for a trivial challenge
+	SolveBase time.Duration
+	// SolveHashRate is the number of hashes per second a modest client is expected to compute
+	SolveHashRate float64
+	// SolveMax caps the solve window for high difficulties
+	SolveMax time.Duration
+	// Write bounds sending a single response
+	Write time.Duration
+}
+
+func defaultTimeouts() Timeouts {
+	return Timeouts{
+		Handshake:     5 * time.Second,
+		SolveBase:     5 * time.Second,
+		SolveHashRate: 500_000,
+		SolveMax:      5 * time.Minute,
+		Write:         5 * time.Second,
+	}
+}
+
+// solveWindow returns the time a client gets to solve a challenge of the difficulty
+func (t Timeouts) solveWindow(difficulty int) time.Duration {
+	// every hex digit of the required zero prefix multiplies the expected work by 16
+	workSeconds := math.Pow(16, float64(difficulty)) / t.SolveHashRate
+	if workSeconds >= (t.SolveMax - t.SolveBase).Seconds() {
+		return t.SolveMax
+	}
+
+	return t.SolveBase + time.Duration(workSeconds*float64(time.Second))
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		KeepAlive:          false,
+		PowGate:            GatePerCommand,
+		CreditsPerSolution: 1,
+		SolutionAttempts:   1,
+		Timeouts:           defaultTimeouts(),
+		SlowClients:        defaultSlowClientPolicy(),
+		MaxRequestSize:     defaultMaxRequestSize,
+	}
+}
+
+// WithPort sets the port of the default TCP listener
+func WithPort(port int) Option {
+	return func(s *TCPServer) {
+		s.port = port
+	}
+}
+
+// WithPowDifficulty sets the number of leading zero hex digits a solution must have
+func WithPowDifficulty(difficulty int) Option {
+	return func(s *TCPServer) {
+		s.powDifficulty = difficulty
+	}
+}
+
+// WithQuotes sets the source of the quotes
+func WithQuotes(quotesService Quoter) Option {
+	return func(s *TCPServer) {
+		s.quotesService = quotesService
+	}
+}
+
+// WithProofOfWork replaces the hashcash challenges
+func WithProofOfWork(powManager ProofOfWorkManager) Option {
+	return func(s *TCPServer) {
+		s.powManager = powManager
+	}
+}
+
+// WithMaxRequestSize bounds a request line and a datagram to n bytes
+func WithMaxRequestSize(n int) Option {
+	return func(s *TCPServer) {
+		if n > 0 {
+			s.settings.MaxRequestSize = n
+		}
+	}
+}
+
+// WithLogger replaces the standard logrus logger, e.g. with one carrying the fields of an embedding program
+func WithLogger(logger log.FieldLogger) Option {
+	return func(s *TCPServer) {
+		s.logger = logger
+	}
+}
+
+// WithClock replaces time.Now for timing the exchanges. Connection deadlines follow
+// the system clock regardless, as they are enforced by the operating system.
+func WithClock(now func() time.Time) Option {
+	return func(s *TCPServer) {
+		s.now = now
+	}
+}
+
+// WithMetricsSink passes the measurements of the server to the sink as they happen
+func WithMetricsSink(sink MetricsSink) Option {
+	return func(s *TCPServer) {
+		s.metrics = sink
+	}
+}
+
+// WithListenerFactory replaces net.Listen for opening the configured listeners,
+// e.g. with in-memory listeners in tests
+func WithListenerFactory(factory ListenerFactory) Option {
+	return func(s *TCPServer) {
+		s.listen = factory
+	}
+}
+
+// WithSettings replaces all the settings, e.g. with ones built from a config file
+func WithSettings(settings Settings) Option {
+	return func(s *TCPServer) {
+		s.settings = settings
+	}
+}
+
+// WithKeepAlive enables command sessions on persistent connections
+func WithKeepAlive(gate PowGate, creditsPerSolution int) Option {
+	return func(s *TCPServer) {
+		s.settings.KeepAlive = true
+		s.settings.PowGate = gate
+		if creditsPerSolution > 0 {
+			s.settings.CreditsPerSolution = creditsPerSolution
+		}
+	}
+}
+
+// WithSolutionAttempts allows clients to retry a challenge up to n times within its deadline
+func WithSolutionAttempts(n int) Option {
+	return func(s *TCPServer) {
+		if n > 0 {
+			s.settings.SolutionAttempts = n
+		}
+	}
+}
+
+// WithReputation replaces the default reputation tracker, e.g. to enable bans
+func WithReputation(tracker ReputationTracker) Option {
+	return func(s *TCPServer) {
+		s.reputation = tracker
+	}
+}
+
+// WithTimeouts overrides the phase deadlines, zero fields keep their defaults
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *TCPServer) {
+		t := &s.settings.Timeouts
+		if timeouts.Handshake > 0 {
+			t.Handshake = timeouts.Handshake
+		}
+		if timeouts.SolveBase > 0 {
+			t.SolveBase = timeouts.SolveBase
+		}
+		if timeouts.SolveHashRate > 0 {
+			t.SolveHashRate = timeouts.SolveHashRate
+		}
+		if timeouts.SolveMax > 0 {
+			t.SolveMax = timeouts.SolveMax
+		}
+		if timeouts.Write > 0 {
+			t.Write = timeouts.Write
+		}
+	}
+}
+
+// WithSlowClientPolicy overrides the slow client policy, zero fields keep their defaults
+func WithSlowClientPolicy(policy SlowClientPolicy) Option {
+	return func(s *TCPServer) {
+		p := &s.settings.SlowClients
+		if policy.IdleGap > 0 {
+			p.IdleGap = policy.IdleGap
+		}
+		if policy.MinThroughput > 0 {
+			p.MinThroughput = policy.MinThroughput
+		}
+		if policy.ThroughputGrace > 0 {
+			p.ThroughputGrace = policy.ThroughputGrace
+		}
+		if policy.PressureConnections > 0 {
+			p.PressureConnections = policy.PressureConnections
+		}
+		if policy.PressureIdleGap > 0 {
+			p.PressureIdleGap = policy.PressureIdleGap
+		}
+	}
+}
+
+// WithWorkerPool bounds the goroutines serving the connections of the stream listeners
+func WithWorkerPool(policy PoolPolicy) Option {
+	return func(s *TCPServer) {
+		s.pool = policy
+	}
+}
+
+// WithTLS serves connections over TLS with the config, see the certs package
+func WithTLS(config *tls.Config) Option {
+	return func(s *TCPServer) {
+		s.tlsConfig = config
+	}
+}
+
+// WithProxyProtocol reads the PROXY protocol headers of TCP connections from the trusted
+// networks, e.g. of a load balancer, so that the clients behind it are told apart.
+// Connections from other sources are taken as they are.
+func WithProxyProtocol(trusted []*net.IPNet) Option {
+	return func(s *TCPServer) {
+		s.proxyTrusted = trusted
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+)
+
+// pipeListener serves in-memory connections made with dial
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+// stepClock advances by step on every reading
+func stepClock(step time.Duration) func() time.Time {
+	var mutex sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestNew(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	sink := mocks.NewMockMetricsSink(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(2).Return("challenge", nil)
+	powManager.EXPECT().VerifySolution("challenge", 42).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	sink.EXPECT().ConnectionAccepted("memory")
+	sink.EXPECT().ChallengeIssued("memory")
+	sink.EXPECT().SolutionAccepted("memory")
+	sink.EXPECT().QuoteServed("memory")
+	sink.EXPECT().ConnectionClosed("memory").AnyTimes()
+	sink.EXPECT().RequestsInProgress(gomock.Any()).AnyTimes()
+	sink.EXPECT().QueueDepth(gomock.Any()).AnyTimes()
+	// every phase is timed with two readings of the clock, the response time spans them all
+	for _, phase := range latencyPhases {
+		sink.EXPECT().PhaseDuration(phase, 100*time.Millisecond)
+	}
+	handled := make(chan struct{})
+	sink.EXPECT().RequestHandled("memory", 800*time.Millisecond).Do(func(string, time.Duration) { close(handled) })
+
+	logger, hook := test.NewNullLogger()
+	l := newPipeListener()
+
+	server, err := New(
+		WithQuotes(quoter),
+		WithProofOfWork(powManager),
+		WithPowDifficulty(2),
+		WithLogger(logger),
+		WithClock(stepClock(100*time.Millisecond)),
+		WithMetricsSink(sink),
+		WithListenerFactory(func(network, address string) (net.Listener, error) {
+			assert.Equal(t, "tcp", network)
+			assert.Equal(t, "in-memory", address)
+			return l, nil
+		}),
+		WithListener(ListenerConfig{Label: "memory", Network: "tcp", Address: "in-memory"}),
+	)
+	assert.NoError(t, err)
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	<-server.Ready()
+
+	conn := l.dial()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	challenge, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "challenge\n", challenge)
+
+	fmt.Fprintln(conn, "42")
+	response, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, quote.String()+"\n", response)
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Error("the request isn't passed to the metrics sink")
+	}
+
+	server.CloseListeners()
+
+	// the server logs to the injected logger only
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "Starting memory listener at pipe://pipe")
+}
+
+func TestNew_QuotesRequired(t *testing.T) {
+	_, err := New(WithPowDifficulty(2))
+	assert.Error(t, err)
+}
+
+func TestWithMaxRequestSize(t *testing.T) {
+	server := NewTCPServer(0, 4, nil, nil, WithMaxRequestSize(64))
+	assert.Equal(t, 64, server.Live().Settings.MaxRequestSize)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// BusyResponse tells clients the server has no room for another connection
+const BusyResponse = "Server is busy, try again later"
+
+const (
+	// minAcceptBackoff is the first pause after a failed accept
+	minAcceptBackoff = 5 * time.Millisecond
+	// maxAcceptBackoff caps the pauses of an accept loop that keeps failing, e.g. on EMFILE
+	maxAcceptBackoff = time.Second
+)
+
+// Overload selects what happens to a connection that arrives while the pool queue is full
+type Overload int
+
+const (
+	// OverloadReject answers the connection with ERR_BUSY at once
+	OverloadReject Overload = iota
+	// OverloadWait stops accepting until there's room in the queue, the connection is
+	// rejected if there's none within PoolPolicy.QueueTimeout
+	OverloadWait
+)
+
+// PoolPolicy bounds the goroutines serving the connections of the stream listeners
+type PoolPolicy struct {
+	// Workers is the number of connections served at once. Zero serves every connection
+	// in a goroutine of its own.
+	Workers int
+	// QueueSize is the number of accepted connections that may wait for a worker
+	QueueSize int
+	// Overload is what happens to a connection that arrives while the queue is full
+	Overload Overload
+	// QueueTimeout is how long OverloadWait holds the accept loop for a place in the queue
+	QueueTimeout time.Duration
+}
+
+func DefaultPoolPolicy() PoolPolicy {
+	return PoolPolicy{
+		Workers:      1024,
+		QueueSize:    128,
+		Overload:     OverloadReject,
+		QueueTimeout: time.Second,
+	}
+}
+
+// poolJob is an accepted connection waiting for a worker
+type poolJob struct {
+	conn     net.Conn
+	listener *listener
+}
+
+// startWorkers starts the workers of the pool, if the pool is bounded
+func (s *TCPServer) startWorkers() {
+	if s.pool.Workers <= 0 {
+		return
+	}
+
+	jobs := make(chan poolJob, s.pool.QueueSize)
+	s.jobsMutex.Lock()
+	s.jobs = jobs
+	s.jobsMutex.Unlock()
+
+	for i := 0; i < s.pool.Workers; i++ {
+		go func() {
+			for job := range jobs {
+				s.collectQueueDepth(-1)
+				s.serveConn(job.conn, job.listener)
+			}
+		}()
+	}
+}
+
+// stopWorkers lets the workers exit once the queued connections are served,
+// connections dispatched afterwards get goroutines of their own
+func (s *TCPServer) stopWorkers() {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	if s.jobs != nil {
+		close(s.jobs)
+		s.jobs = nil
+	}
+}
+
+// dispatch passes an accepted connection to the pool, or turns it away when the pool is overloaded.
+// The connections of the listeners and the WebSocket sessions go through it.
+func (s *TCPServer) dispatch(conn net.Conn, l *listener) {
+	s.jobsMutex.RLock()
+	defer s.jobsMutex.RUnlock()
+
+	if s.jobs == nil {
+		go s.serveConn(conn, l)
+		return
+	}
+
+	job := poolJob{conn: conn, listener: l}
+
+	// the connection is counted before it's queued, so that the depth never goes below zero
+	s.collectQueueDepth(1)
+	select {
+	case s.jobs <- job:
+		return
+	default:
+	}
+
+	if s.pool.Overload == OverloadWait {
+		timer := time.NewTimer(s.pool.QueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.jobs <- job:
+			return
+		case <-timer.C:
+		case <-s.ctx.Done():
+		}
+	}
+
+	s.collectQueueDepth(-1)
+	s.drop(conn, l)
+}
+
+// drop answers a connection the pool has no room for and closes it
+func (s *TCPServer) drop(rawConn net.Conn, l *listener) {
+	conn := &countingConn{Conn: rawConn}
+	access := newAccessRecord(s.connIDs.Add(1), conn, l, s.now())
+	defer s.logAccess(access)
+	defer conn.Close()
+
+	s.collectDropped(l.label)
+	s.logger.Warnf("dropped connection from %s on %s: the worker pool is full", remoteAddr(conn), l.label)
+
+	// a TLS client would need a handshake to read the answer, which costs more than the connection is worth
+	if s.tlsConfig != nil && l.encrypted() {
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(s.liveConfig().Settings.Timeouts.Write))
+	newTextCodec(nil, conn).Write(protocol.NewError(protocol.ErrCodeBusy, BusyResponse))
+	access.outcome = protocol.ErrCodeBusy
+}
+
+// nextAcceptBackoff doubles the pause after a failed accept up to maxAcceptBackoff
+func nextAcceptBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return minAcceptBackoff
+	}
+
+	backoff *= 2
+	if backoff > maxAcceptBackoff {
+		return maxAcceptBackoff
+	}
+
+	return backoff
+}
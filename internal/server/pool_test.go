@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+func TestWithWorkerPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		overload Overload
+	}{
+		{name: "reject", overload: OverloadReject},
+		{name: "wait", overload: OverloadWait},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := gomock.NewController(t)
+			defer c.Finish()
+
+			quoter := mocks.NewMockQuoter(c)
+			powManager := mocks.NewMockProofOfWorkManager(c)
+			sink := mocks.NewMockMetricsSink(c)
+
+			// the queued client is served once the test is over
+			powManager.EXPECT().GenerateChallenge(2).Return("challenge", nil).AnyTimes()
+
+			sink.EXPECT().ConnectionAccepted("memory").AnyTimes()
+			sink.EXPECT().ConnectionClosed("memory").AnyTimes()
+			sink.EXPECT().ChallengeIssued("memory").AnyTimes()
+			sink.EXPECT().PhaseDuration(gomock.Any(), gomock.Any()).AnyTimes()
+			sink.EXPECT().RequestsInProgress(gomock.Any()).AnyTimes()
+			sink.EXPECT().Timeout(gomock.Any()).AnyTimes()
+			sink.EXPECT().SlowClient(gomock.Any()).AnyTimes()
+			sink.EXPECT().QueueDepth(gomock.Any()).AnyTimes()
+			sink.EXPECT().ConnectionDropped("memory")
+
+			l := newPipeListener()
+			server, err := New(
+				WithQuotes(quoter),
+				WithProofOfWork(powManager),
+				WithPowDifficulty(2),
+				WithMetricsSink(sink),
+				WithWorkerPool(PoolPolicy{Workers: 1, QueueSize: 1, Overload: tt.overload, QueueTimeout: 50 * time.Millisecond}),
+				WithListenerFactory(func(network, address string) (net.Listener, error) { return l, nil }),
+				WithListener(ListenerConfig{Label: "memory", Network: "tcp", Address: "in-memory"}),
+			)
+			assert.NoError(t, err)
+
+			go func() {
+				err := server.ListenAndServe()
+				assert.NoError(t, err)
+			}()
+			<-server.Ready()
+			defer server.CloseListeners()
+
+			// the only worker is busy with the first client
+			busy := l.dial()
+			defer busy.Close()
+
+			challenge, err := bufio.NewReader(busy).ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, "challenge\n", challenge)
+
+			queued := l.dial()
+			defer queued.Close()
+
+			// the queue is full now
+			dropped := l.dial()
+			defer dropped.Close()
+
+			response, err := bufio.NewReader(dropped).ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, protocol.ErrCodeBusy+" "+BusyResponse+"\n", response)
+
+			assert.Equal(t, 1, server.stats().droppedConnections)
+			assert.Equal(t, 1, server.stats().queueDepth)
+		})
+	}
+}
+
+func TestNextAcceptBackoff(t *testing.T) {
+	var backoffs []time.Duration
+	backoff := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		backoff = nextAcceptBackoff(backoff)
+		backoffs = append(backoffs, backoff)
+	}
+
+	assert.Equal(t, minAcceptBackoff, backoffs[0])
+	assert.Equal(t, 2*minAcceptBackoff, backoffs[1])
+	assert.Equal(t, maxAcceptBackoff, backoffs[9])
+}
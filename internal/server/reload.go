@@ -0,0 +1,45 @@
+package server
+
+// LiveConfig is the part of the server configuration that can be replaced while serving, see Reload
+type LiveConfig struct {
+	PowDifficulty int
+	Settings      Settings
+	Quotes        Quoter
+}
+
+// Live returns the configuration new connections are served with
+func (s *TCPServer) Live() LiveConfig {
+	return *s.live.Load()
+}
+
+// Reload swaps in the configuration for new connections and datagrams. Connections being served
+// finish under the configuration they started with. The configuration is expected to be validated.
+func (s *TCPServer) Reload(config LiveConfig) {
+	s.liveMutex.Lock()
+	defer s.liveMutex.Unlock()
+
+	s.live.Store(&config)
+
+	s.logger.Infof("Reloaded the configuration: difficulty=%d keep_alive=%t", config.PowDifficulty, config.Settings.KeepAlive)
+}
+
+// UpdateLive changes a part of the configuration like Reload does and returns the result.
+// The update gets a copy of the current configuration; updates and reloads are applied
+// one at a time, so none of them overwrites the changes of another.
+func (s *TCPServer) UpdateLive(update func(config *LiveConfig)) LiveConfig {
+	s.liveMutex.Lock()
+	defer s.liveMutex.Unlock()
+
+	config := *s.live.Load()
+	update(&config)
+	s.live.Store(&config)
+
+	s.logger.Infof("Updated the configuration: difficulty=%d keep_alive=%t", config.PowDifficulty, config.Settings.KeepAlive)
+
+	return config
+}
+
+// liveConfig returns the configuration to serve a new connection with
+func (s *TCPServer) liveConfig() *LiveConfig {
+	return s.live.Load()
+}
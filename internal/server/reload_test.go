@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+)
+
+func TestTCPServer_Reload(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	oldQuoter := mocks.NewMockQuoter(c)
+	newQuoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	oldQuote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+	newQuote := quotes.Quote{Text: "Patience is the companion of wisdom.", Author: "Saint Augustine"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("old", nil)
+	powManager.EXPECT().GenerateChallenge(6).Return("new", nil)
+	powManager.EXPECT().VerifySolution("old", 1).Return(true, nil)
+	powManager.EXPECT().VerifySolution("new", 2).Return(true, nil)
+	oldQuoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(oldQuote, nil)
+	newQuoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(newQuote, nil)
+
+	server := NewTCPServer(0, 4, oldQuoter, powManager)
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	connect := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", server.getAddr())
+		assert.NoError(t, err)
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	inFlight, inFlightReader := connect()
+	defer inFlight.Close()
+
+	greeting, err := inFlightReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "old\n", greeting)
+
+	server.Reload(LiveConfig{PowDifficulty: 6, Settings: DefaultSettings(), Quotes: newQuoter})
+	assert.Equal(t, 6, server.Live().PowDifficulty)
+
+	// new connections get the new difficulty and quotes
+	fresh, freshReader := connect()
+	defer fresh.Close()
+
+	greeting, err = freshReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", greeting)
+
+	fmt.Fprintln(fresh, "2")
+	response, err := freshReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, newQuote.String()+"\n", response)
+
+	// the connection that arrived before the reload finishes under the old config
+	fmt.Fprintln(inFlight, "1")
+	response, err = inFlightReader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, oldQuote.String()+"\n", response)
+}
+
+func TestTCPServer_UpdateLive(t *testing.T) {
+	quoter := mocks.NewMockQuoter(gomock.NewController(t))
+	server := NewTCPServer(0, 4, nil, nil)
+
+	// concurrent updates of different settings don't lose each other's changes
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			server.UpdateLive(func(config *LiveConfig) { config.PowDifficulty++ })
+		}()
+		go func() {
+			defer wg.Done()
+			server.UpdateLive(func(config *LiveConfig) { config.Settings.SolutionAttempts++ })
+		}()
+	}
+	wg.Wait()
+
+	live := server.UpdateLive(func(config *LiveConfig) { config.Quotes = quoter })
+	assert.Equal(t, 54, live.PowDifficulty)
+	assert.Equal(t, DefaultSettings().SolutionAttempts+50, live.Settings.SolutionAttempts)
+	assert.Equal(t, live, server.Live())
+}
@@ -1,32 +1,71 @@
 package server
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
-	"strconv"
-	"strings"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/zhashkevych/quotes-server/internal/metrics"
+	"github.com/zhashkevych/quotes-server/internal/proxyproto"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/reputation"
+	"github.com/zhashkevych/quotes-server/pkg/hashcash"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
 )
 
 //go:generate mockgen -source=server.go -destination=mocks/mock.go
 
 const (
 	IncorrectSolutionResonse    = "Incorrect solution. Try again."
+	InvalidSolutionResponse     = "Invalid solution format"
 	InternalServerErrorResponse = "Internal server error"
+	BannedResponse              = "Too many failed attempts. Try again later."
+	QuoteNotFoundResponse       = "No quotes found"
+	TimeoutResponse             = "Timed out waiting for the request"
+	SlowClientResponse          = "Request is sent too slowly"
+	ExpiredResponse             = "Challenge expired before a solution arrived"
 )
 
+const defaultMaxRequestSize = 1024 // 1KB
+
+// DefaultPowDifficulty is the difficulty of the challenges unless set with WithPowDifficulty
+const DefaultPowDifficulty = 4
+
+// phase names a stage of the exchange with its own deadline
+type phase string
+
 const (
-	timeout        = time.Second * 5
-	maxRequestSize = 1024 // 1KB
+	phaseHandshake phase = "handshake"
+	phaseSolve     phase = "solve"
+	phaseWrite     phase = "write"
 )
 
+// the phases of an exchange that are timed, see MetricsSink.PhaseDuration
+const (
+	// latencyChallengeSend is writing a challenge
+	latencyChallengeSend = "challenge_send"
+	// latencySolve is from a challenge sent to a solution received, the time the client takes
+	latencySolve = "solve"
+	// latencyVerify is verifying a solution
+	latencyVerify = "verify"
+	// latencyQuoteSend is writing the quotes of a request
+	latencyQuoteSend = "quote_send"
+)
+
+// latencyPhases are the timed phases in the order of an exchange
+var latencyPhases = []string{latencyChallengeSend, latencySolve, latencyVerify, latencyQuoteSend}
+
 type Quoter interface {
-	GetRandomQuote() string
+	GetRandomQuote(filter quotes.Filter) (quotes.Quote, error)
+	Categories() []string
 }
 
 type ProofOfWorkManager interface {
@@ -35,170 +74,726 @@ type ProofOfWorkManager interface {
 	VerifySolution(challenge string, nonce int) (bool, error)
 }
 
+// ReputationTracker keeps score of clients' proof-of-work attempts
+type ReputationTracker interface {
+	RecordFailure(client string)
+	RecordSuccess(client string)
+	IsBanned(client string) bool
+}
+
+// MetricsSink receives the measurements of the server as they happen, e.g. to export them.
+// The server keeps the totals it reports on shutdown on its own.
+type MetricsSink interface {
+	// ConnectionAccepted counts a connection of the listener with the label
+	ConnectionAccepted(listener string)
+	// ConnectionClosed counts a connection of the listener that has been closed
+	ConnectionClosed(listener string)
+	// RequestHandled counts a served quote and the time it took to serve it
+	RequestHandled(listener string, responseTime time.Duration)
+	// ChallengeIssued counts a challenge issued through the listener
+	ChallengeIssued(listener string)
+	// SolutionAccepted counts a correct solution
+	SolutionAccepted(listener string)
+	// SolutionRejected counts a solution rejected for the reason: malformed, wrong, forged, expired or replayed
+	SolutionRejected(listener, reason string)
+	// QuoteServed counts a quote sent through the listener
+	QuoteServed(listener string)
+	// RateLimited counts a request of a banned client
+	RateLimited(listener string)
+	// PhaseDuration reports how long a phase of a TCP or WebSocket exchange took:
+	// challenge_send, solve, verify or quote_send
+	PhaseDuration(phase string, duration time.Duration)
+	// Timeout counts a deadline of the phase: handshake, solve or write
+	Timeout(phase string)
+	// RequestsInProgress changes the number of connections in the middle of a request by delta
+	RequestsInProgress(delta int)
+	// SlowClient counts a connection closed by the slow client policy: trickling, half_open or shed
+	SlowClient(reason string)
+	// ProxyRejected counts a connection without a valid PROXY protocol header
+	ProxyRejected()
+	// QueueDepth reports the number of connections waiting for a worker
+	QueueDepth(depth int)
+	// ConnectionDropped counts a connection of the listener turned away because the worker pool was full
+	ConnectionDropped(listener string)
+}
+
+// the reasons of rejected solutions passed to MetricsSink.SolutionRejected
+const (
+	rejectedMalformed = "malformed"
+	rejectedWrong     = "wrong"
+	rejectedForged    = "forged"
+	rejectedExpired   = "expired"
+	rejectedReplayed  = "replayed"
+)
+
+type nopMetrics struct{}
+
+func (nopMetrics) ConnectionAccepted(string)            {}
+func (nopMetrics) ConnectionClosed(string)              {}
+func (nopMetrics) RequestHandled(string, time.Duration) {}
+func (nopMetrics) ChallengeIssued(string)               {}
+func (nopMetrics) SolutionAccepted(string)              {}
+func (nopMetrics) SolutionRejected(string, string)      {}
+func (nopMetrics) QuoteServed(string)                   {}
+func (nopMetrics) RateLimited(string)                   {}
+func (nopMetrics) PhaseDuration(string, time.Duration)  {}
+func (nopMetrics) Timeout(string)                       {}
+func (nopMetrics) RequestsInProgress(int)               {}
+func (nopMetrics) SlowClient(string)                    {}
+func (nopMetrics) ProxyRejected()                       {}
+func (nopMetrics) QueueDepth(int)                       {}
+func (nopMetrics) ConnectionDropped(string)             {}
+
+// ListenerFactory opens the listeners of the server, net.Listen by default
+type ListenerFactory func(network, address string) (net.Listener, error)
+
 type TCPServer struct {
-	port          int
+	port       int
+	powManager ProofOfWorkManager
+	reputation ReputationTracker
+	logger     log.FieldLogger
+	now        func() time.Time
+	metrics    MetricsSink
+	listen     ListenerFactory
+	// powDifficulty and quotesService are staged by the options, like settings
 	powDifficulty int
 	quotesService Quoter
-	powManager    ProofOfWorkManager
+	// settings are staged by the options, connections are served with the live config.
+	// liveMutex serializes the changes of the live config, readers just load it.
+	settings     Settings
+	live         atomic.Pointer[LiveConfig]
+	liveMutex    sync.Mutex
+	tlsConfig    *tls.Config
+	proxyTrusted []*net.IPNet
+	hooks        hookChain
+	connIDs      atomic.Uint64
+	// accessLog is nil unless set by WithAccessLog
+	accessLog        log.FieldLogger
+	accessSampleRate float64
+	// pool bounds the goroutines serving connections, jobs is its queue while serving.
+	// The WebSocket gateway dispatches from the goroutines of the HTTP server, so jobs is
+	// guarded by jobsMutex.
+	pool      PoolPolicy
+	jobsMutex sync.RWMutex
+	jobs      chan poolJob
+
+	listenerConfigs []ListenerConfig
+	listeners       []*listener
+	// ready is closed once the listeners are open
+	ready         chan struct{}
+	closeAccept   sync.Once
+	acceptStopped atomic.Bool
 
-	listener     net.Listener
 	shutdownChan chan struct{}
-	connections  map[net.Conn]struct{}
+	connections  map[net.Conn]*connState
+	draining     bool
 	connMutex    sync.Mutex
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	// Metrics
+	queueDepth           int
+	droppedConnections   int
 	totalRequestsHandled int
 	totalResponseTime    time.Duration
+	totalFailedAttempts  int
+	timeouts             map[phase]int
+	pendingRequests      int
+	tricklingConnections int
+	halfOpenConnections  int
+	shedConnections      int
+	listenerStats        map[string]*listenerStats
+	latencies            *metrics.Histogram
+	proxyRejected        int
+	drainReport          DrainReport
 	metricsMutex         sync.Mutex
 }
 
-func NewTCPServer(port, powDifficulty int, quotesService Quoter, powManager ProofOfWorkManager) *TCPServer {
+// NewTCPServer creates a server at the port with the dependencies, the options may override them
+func NewTCPServer(port, powDifficulty int, quotesService Quoter, powManager ProofOfWorkManager, opts ...Option) *TCPServer {
+	deps := []Option{WithPort(port), WithPowDifficulty(powDifficulty), WithQuotes(quotesService), WithProofOfWork(powManager)}
+
+	return newTCPServer(append(deps, opts...)...)
+}
+
+// New creates a server configured with the options. The quotes are required, see WithQuotes.
+// Without options the server listens at port 9000, issues hashcash challenges of
+// DefaultPowDifficulty and logs with the standard logrus logger.
+func New(opts ...Option) (*TCPServer, error) {
+	s := newTCPServer(opts...)
+	if s.liveConfig().Quotes == nil {
+		return nil, errors.New("the quotes are not set, see WithQuotes")
+	}
+
+	return s, nil
+}
+
+func newTCPServer(opts ...Option) *TCPServer {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &TCPServer{
-		port:          port,
-		powDifficulty: powDifficulty,
-		quotesService: quotesService,
-		powManager:    powManager,
+	s := &TCPServer{
+		port:          9000,
+		powDifficulty: DefaultPowDifficulty,
+		powManager:    hashcash.New(),
+		reputation:    reputation.New(reputation.Config{}),
+		logger:        log.StandardLogger(),
+		now:           time.Now,
+		metrics:       nopMetrics{},
+		listen:        net.Listen,
+		settings:      DefaultSettings(),
+		pool:          DefaultPoolPolicy(),
 		shutdownChan:  make(chan struct{}),
-		connections:   make(map[net.Conn]struct{}),
+		connections:   make(map[net.Conn]*connState),
+		timeouts:      make(map[phase]int),
+		listenerStats: make(map[string]*listenerStats),
+		latencies:     metrics.NewHistogram(metrics.LatencyBuckets, "phase"),
+		ready:         make(chan struct{}),
 		ctx:           ctx,
 		cancel:        cancel,
 	}
+	s.hooks = hookChain{s.banHooks()}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.live.Store(&LiveConfig{PowDifficulty: s.powDifficulty, Settings: s.settings, Quotes: s.quotesService})
+
+	return s
 }
 
+// ListenAndServe opens the configured listeners and serves them until shutdown.
+// Without configured listeners it listens on TCP on all interfaces at the server port.
 func (s *TCPServer) ListenAndServe() error {
-	var err error
-	s.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", s.port))
-	if err != nil {
-		return err
+	configs := s.listenerConfigs
+	if len(configs) == 0 && len(s.listeners) == 0 {
+		configs = []ListenerConfig{{Label: "tcp", Network: "tcp", Address: fmt.Sprintf(":%d", s.port)}}
+	}
+
+	listeners := append([]*listener{}, s.listeners...)
+	for _, config := range configs {
+		l, err := config.listen(s.listen)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	for _, l := range listeners {
+		l.raw = l.Listener
+		if l.proxied() {
+			l.Listener = s.proxyListener(l.Listener)
+		}
 	}
+	s.listeners = listeners
+	close(s.ready)
 
-	defer s.listener.Close()
+	if s.acceptStopped.Load() {
+		s.CloseListeners()
+	}
 
 	go func() {
 		<-s.ctx.Done()
-		s.listener.Close()
-		log.Info("TCP server is shutting down")
+		s.CloseListeners()
+		s.logger.Info("TCP server is shutting down")
 	}()
 
-	log.Infof("Starting TCP server at :%d", s.port)
+	s.startWorkers()
+	defer s.stopWorkers()
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *listener) {
+			errs <- s.serve(l)
+		}(l)
+	}
+
+	var err error
+	for range listeners {
+		if serveErr := <-errs; serveErr != nil && err == nil {
+			err = serveErr
+		}
+	}
+
+	return err
+}
+
+// serve accepts connections of the listener until shutdown
+func (s *TCPServer) serve(l *listener) error {
+	defer l.Close()
+	defer l.down.Store(true)
+
+	s.logger.Infof("Starting %s listener at %s://%s", l.label, l.Addr().Network(), l.Addr().String())
 
+	var backoff time.Duration
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
+			if s.acceptStopped.Load() {
+				return nil
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			// errors such as running out of file descriptors last a while, retrying at once would spin
+			backoff = nextAcceptBackoff(backoff)
+			s.logger.Errorf("Error accepting on %s: %s, retrying in %s", l.label, err.Error(), backoff)
 			select {
+			case <-time.After(backoff):
 			case <-s.ctx.Done():
-				return nil
-			default:
-				log.Error("Error accepting:", err.Error())
 			}
 			continue
 		}
-		go s.handleConnection(conn)
+		backoff = 0
+
+		s.dispatch(conn, l)
 	}
 }
 
-func (s *TCPServer) handleConnection(conn net.Conn) {
-	startTime := time.Now()
+// Ready is closed once the server listens, e.g. to tell a parent process
+// that the listeners it passed are served
+func (s *TCPServer) Ready() <-chan struct{} {
+	return s.ready
+}
 
-	// store current connection for graceful shutdown logic
-	s.connMutex.Lock()
-	s.connections[conn] = struct{}{}
-	s.connMutex.Unlock()
+// Addrs returns the addresses of the listeners by label, nil until the server listens
+func (s *TCPServer) Addrs() map[string]net.Addr {
+	select {
+	case <-s.ready:
+	default:
+		return nil
+	}
 
-	defer func() {
-		s.connMutex.Lock()
-		delete(s.connections, conn)
-		s.connMutex.Unlock()
-		conn.Close()
-	}()
+	addrs := make(map[string]net.Addr, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs[l.label] = l.Addr()
+	}
 
-	// process request
-	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
-		fmt.Fprintf(conn, "%s\n", InternalServerErrorResponse)
+	return addrs
+}
+
+// CloseListeners stops accepting connections without ending the ones in progress,
+// e.g. once the listeners have been passed to a new process
+func (s *TCPServer) CloseListeners() {
+	s.acceptStopped.Store(true)
+
+	// listeners opened later are closed by ListenAndServe
+	select {
+	case <-s.ready:
+	default:
 		return
 	}
 
-	log.Infof("received request from %s", conn.RemoteAddr().String())
+	s.closeAccept.Do(func() {
+		for _, l := range s.listeners {
+			l.Close()
+		}
+	})
+}
 
-	defer conn.Close()
+// Files returns duplicates of the listening sockets by listener label to pass to another process.
+// Unix socket files are kept on disk when the listeners are closed.
+func (s *TCPServer) Files() (map[string]*os.File, error) {
+	select {
+	case <-s.ready:
+	default:
+		return nil, errors.New("server isn't listening")
+	}
 
-	reader := bufio.NewReader(conn)
-	reader = bufio.NewReaderSize(reader, maxRequestSize)
+	files := make(map[string]*os.File, len(s.listeners))
+	for _, l := range s.listeners {
+		file, err := listenerFile(l.raw)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("listener %s: %w", l.label, err)
+		}
+		files[l.label] = file
+	}
 
-	challenge, err := s.powManager.GenerateChallenge(s.powDifficulty)
-	if err != nil {
-		fmt.Fprintf(conn, "%s\n", InternalServerErrorResponse)
-		return
+	return files, nil
+}
+
+// listenerFile returns a duplicate of the listening socket
+func listenerFile(l net.Listener) (*os.File, error) {
+	if unixListener, ok := l.(*net.UnixListener); ok {
+		// the new process serves the same socket file
+		unixListener.SetUnlinkOnClose(false)
+	}
+
+	filer, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("listener has no file")
+	}
+
+	return filer.File()
+}
+
+// proxyListener reads the PROXY protocol headers of connections from the trusted networks,
+// the listener is returned as is if the protocol isn't enabled
+func (s *TCPServer) proxyListener(l net.Listener) net.Listener {
+	if len(s.proxyTrusted) == 0 {
+		return l
+	}
+
+	proxied := proxyproto.NewListener(l, s.proxyTrusted, s.liveConfig().Settings.Timeouts.Handshake)
+	// headers are read before the pool sees the connections, so they're bounded like the workers
+	proxied.MaxHandshakes = s.pool.Workers
+	proxied.OnReject = func(addr net.Addr, err error) {
+		s.logger.Errorf("rejected connection from %s: %s", addr, err)
+		s.collectProxyRejected()
+	}
+
+	return proxied
+}
+
+// serveConn runs the exchange on a connection of the listener, starting with a TLS handshake
+// if TLS is configured and the listener is a TCP one.
+// Gateways that terminate TLS themselves pass their connections here directly.
+func (s *TCPServer) serveConn(rawConn net.Conn, l *listener) {
+	startTime := s.now()
+	// the connection is served under the config it arrived with, even if it's reloaded meanwhile
+	config := s.liveConfig()
+
+	counted := &countingConn{Conn: rawConn}
+	access := newAccessRecord(s.connIDs.Add(1), counted, l, startTime)
+	defer s.logAccess(access)
+
+	guarded := s.guard(counted, config.Settings.SlowClients)
+
+	info := ConnInfo{
+		ID:         access.id,
+		Client:     clientHost(guarded),
+		RemoteAddr: guarded.RemoteAddr(),
+		Listener:   l.label,
+		Policy:     l.policy,
+		Accepted:   startTime,
 	}
-	fmt.Fprintf(conn, "%s\n", challenge)
 
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		if err == bufio.ErrBufferFull {
-			fmt.Fprintf(conn, "Request data too large. Limit is %d bytes.\n", maxRequestSize)
+	// store current connection for graceful shutdown logic and the control plane
+	state := &connState{conn: guarded, info: info}
+	s.connMutex.Lock()
+	s.connections[guarded] = state
+	arrivedDraining := s.draining
+	s.connMutex.Unlock()
+
+	defer func() {
+		state.finish()
+		s.connMutex.Lock()
+		delete(s.connections, guarded)
+		s.connMutex.Unlock()
+		guarded.Close()
+	}()
+
+	s.shedSlowClients()
+	s.collectConnection(l.label)
+	defer s.metrics.ConnectionClosed(l.label)
+
+	s.logger.Debugf("received request from %s on %s", remoteAddr(guarded), l.label)
+
+	var conn net.Conn = guarded
+	if s.tlsConfig != nil && l.encrypted() {
+		tlsConn, err := s.handshakeTLS(guarded, config.Settings.Timeouts.Handshake)
+		if err != nil {
+			s.logger.Errorf("TLS handshake with %s failed: %s", remoteAddr(guarded), err)
+			access.outcome = outcomeTLSFailed
 			return
 		}
-		fmt.Fprintf(conn, "%s\n", IncorrectSolutionResonse)
-		return
+		conn = tlsConn
 	}
 
-	nonce, err := strconv.Atoi(strings.TrimSpace(response))
-	if err != nil {
-		fmt.Fprintf(conn, "%s\n", IncorrectSolutionResonse)
+	if arrivedDraining {
+		conn.SetWriteDeadline(time.Now().Add(config.Settings.Timeouts.Write))
+		newTextCodec(nil, conn).Write(protocol.NewError(protocol.ErrCodeShuttingDown, ShuttingDownResponse))
+		access.outcome = protocol.ErrCodeShuttingDown
 		return
 	}
 
-	log.Infof("received solution:  %d", nonce)
+	defer s.hooks.close(info)
 
-	isValid, err := s.powManager.VerifySolution(challenge, nonce)
-	if err != nil {
-		fmt.Fprintf(conn, "%s\n", IncorrectSolutionResonse)
+	if err := s.hooks.accept(info); err != nil {
+		s.logger.Infof("connection from %s is rejected: %s", remoteAddr(conn), err)
+		conn.SetWriteDeadline(time.Now().Add(config.Settings.Timeouts.Write))
+		msg := rejection(err)
+		newTextCodec(nil, conn).Write(msg)
+		access.sent(msg)
 		return
 	}
 
-	if !isValid {
-		fmt.Fprintf(conn, "%s\n", IncorrectSolutionResonse)
-		return
+	s.newSession(conn, l, state, config, info, access).serve()
+}
+
+// handshakeTLS runs the TLS handshake on top of the guarded connection,
+// so that the slow client policy covers the handshake as well
+func (s *TCPServer) handshakeTLS(conn net.Conn, timeout time.Duration) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, s.tlsConfig)
+
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		s.collectTimeout(phaseHandshake)
 	}
 
-	fmt.Fprintf(conn, "%s\n", s.quotesService.GetRandomQuote())
+	return tlsConn, err
+}
 
-	endTime := time.Now()
+// collectMetrics records a request served through the listener with the label
+func (s *TCPServer) collectMetrics(label string, startTime time.Time) {
+	responseTime := s.now().Sub(startTime)
 
-	// collect metrics
 	s.metricsMutex.Lock()
 	s.totalRequestsHandled++
-	s.totalResponseTime += endTime.Sub(startTime)
+	s.totalResponseTime += responseTime
+	s.listenerStatsFor(label).requestsHandled++
 	s.metricsMutex.Unlock()
+
+	s.metrics.RequestHandled(label, responseTime)
 }
 
-func (s *TCPServer) Shutdown() {
-	s.cancel()
+func (s *TCPServer) collectProxyRejected() {
+	s.metricsMutex.Lock()
+	s.proxyRejected++
+	s.metricsMutex.Unlock()
+
+	s.metrics.ProxyRejected()
+}
 
-	s.connMutex.Lock()
-	for conn := range s.connections {
-		conn.Close()
-		delete(s.connections, conn)
+func (s *TCPServer) collectConnection(label string) {
+	s.metricsMutex.Lock()
+	s.listenerStatsFor(label).connections++
+	s.metricsMutex.Unlock()
+
+	s.metrics.ConnectionAccepted(label)
+}
+
+// listenerStatsFor returns the counters of the listener, metricsMutex must be held
+func (s *TCPServer) listenerStatsFor(label string) *listenerStats {
+	st, ok := s.listenerStats[label]
+	if !ok {
+		st = &listenerStats{}
+		s.listenerStats[label] = st
 	}
-	s.connMutex.Unlock()
 
-	s.logMetrics()
+	return st
 }
 
-func (s *TCPServer) logMetrics() {
+// collectFailedAttempt records a solution rejected for the reason that counts against the client
+func (s *TCPServer) collectFailedAttempt(label, reason string) {
 	s.metricsMutex.Lock()
+	s.totalFailedAttempts++
+	s.metricsMutex.Unlock()
+
+	s.metrics.SolutionRejected(label, reason)
+}
+
+func (s *TCPServer) collectTimeout(p phase) {
+	s.metricsMutex.Lock()
+	s.timeouts[p]++
+	s.metricsMutex.Unlock()
+
+	s.metrics.Timeout(string(p))
+}
+
+// collectRequestProgress tracks the number of connections in the middle of a request
+func (s *TCPServer) collectRequestProgress(delta int) {
+	s.metricsMutex.Lock()
+	s.pendingRequests += delta
+	s.metricsMutex.Unlock()
+
+	s.metrics.RequestsInProgress(delta)
+}
+
+// collectQueueDepth tracks the number of connections waiting for a worker
+func (s *TCPServer) collectQueueDepth(delta int) {
+	s.metricsMutex.Lock()
+	s.queueDepth += delta
+	depth := s.queueDepth
+	s.metricsMutex.Unlock()
+
+	s.metrics.QueueDepth(depth)
+}
+
+func (s *TCPServer) collectDropped(label string) {
+	s.metricsMutex.Lock()
+	s.droppedConnections++
+	s.listenerStatsFor(label).dropped++
+	s.metricsMutex.Unlock()
+
+	s.metrics.ConnectionDropped(label)
+}
+
+// collectLatency records how long the phase of an exchange took
+func (s *TCPServer) collectLatency(phase string, duration time.Duration) {
+	s.latencies.Observe(duration.Seconds(), phase)
+	s.metrics.PhaseDuration(phase, duration)
+}
+
+func (s *TCPServer) collectTrickling() {
+	s.metricsMutex.Lock()
+	s.tricklingConnections++
+	s.metricsMutex.Unlock()
+
+	s.metrics.SlowClient("trickling")
+}
+
+func (s *TCPServer) collectHalfOpen() {
+	s.metricsMutex.Lock()
+	s.halfOpenConnections++
+	s.metricsMutex.Unlock()
+
+	s.metrics.SlowClient("half_open")
+}
+
+func (s *TCPServer) collectShed() {
+	s.metricsMutex.Lock()
+	s.shedConnections++
+	s.metricsMutex.Unlock()
+
+	s.metrics.SlowClient("shed")
+}
+
+type serverStats struct {
+	requestsHandled     int
+	averageResponseTime time.Duration
+	failedAttempts      int
+	timeouts            map[phase]int
+	// pendingRequests is the number of connections in the middle of a request
+	pendingRequests int
+	// tricklingConnections were closed for violating the slow client policy
+	tricklingConnections int
+	// halfOpenConnections were closed by the client in the middle of a request
+	halfOpenConnections int
+	// shedConnections stalled in the middle of a request and were closed under pressure
+	shedConnections int
+	// proxyRejected connections from trusted networks had no valid PROXY protocol header
+	proxyRejected int
+	// queueDepth is the number of connections waiting for a worker
+	queueDepth int
+	// droppedConnections were turned away because the worker pool was full
+	droppedConnections int
+	// drain is how the connections open at shutdown ended
+	drain DrainReport
+	// listeners are the counters by listener label
+	listeners map[string]listenerStats
+}
+
+// listenerStats count the traffic of a single listener
+type listenerStats struct {
+	connections     int
+	requestsHandled int
+	dropped         int
+}
+
+func (st serverStats) totalTimeouts() int {
+	total := 0
+	for _, n := range st.timeouts {
+		total += n
+	}
+
+	return total
+}
+
+func (s *TCPServer) stats() serverStats {
+	s.metricsMutex.Lock()
+	defer s.metricsMutex.Unlock()
 
 	averageResponseTime := time.Duration(0)
 	if s.totalRequestsHandled > 0 {
 		averageResponseTime = s.totalResponseTime / time.Duration(s.totalRequestsHandled)
 	}
 
-	log.Infof("Total requests handled: %d", s.totalRequestsHandled)
-	log.Infof("Average response time: %s", averageResponseTime)
+	listeners := make(map[string]listenerStats, len(s.listenerStats))
+	for label, st := range s.listenerStats {
+		listeners[label] = *st
+	}
 
-	s.metricsMutex.Unlock()
+	return serverStats{
+		requestsHandled:     s.totalRequestsHandled,
+		averageResponseTime: averageResponseTime,
+		failedAttempts:      s.totalFailedAttempts,
+		timeouts: map[phase]int{
+			phaseHandshake: s.timeouts[phaseHandshake],
+			phaseSolve:     s.timeouts[phaseSolve],
+			phaseWrite:     s.timeouts[phaseWrite],
+		},
+		pendingRequests:      s.pendingRequests,
+		tricklingConnections: s.tricklingConnections,
+		halfOpenConnections:  s.halfOpenConnections,
+		shedConnections:      s.shedConnections,
+		proxyRejected:        s.proxyRejected,
+		queueDepth:           s.queueDepth,
+		droppedConnections:   s.droppedConnections,
+		drain:                s.drainReport,
+		listeners:            listeners,
+	}
+}
+
+func (s *TCPServer) logMetrics() {
+	stats := s.stats()
+
+	s.logger.Infof("Total requests handled: %d", stats.requestsHandled)
+	s.logger.Infof("Average response time: %s", stats.averageResponseTime)
+	s.logger.Infof("Failed solution attempts: %d", stats.failedAttempts)
+	s.logger.Infof("Timeouts: handshake=%d solve=%d write=%d",
+		stats.timeouts[phaseHandshake], stats.timeouts[phaseSolve], stats.timeouts[phaseWrite])
+	s.logger.Infof("Slow clients: trickling=%d half_open=%d shed=%d",
+		stats.tricklingConnections, stats.halfOpenConnections, stats.shedConnections)
+
+	s.logger.Infof("Worker pool: queued=%d dropped=%d", stats.queueDepth, stats.droppedConnections)
+
+	for _, phase := range latencyPhases {
+		if s.latencies.Count(phase) == 0 {
+			continue
+		}
+		s.logger.Infof("Latency %s: p50=%s p90=%s p99=%s", phase,
+			s.latencyQuantile(phase, 0.5), s.latencyQuantile(phase, 0.9), s.latencyQuantile(phase, 0.99))
+	}
+
+	s.logger.Infof("Drained connections: drained=%d killed=%d", stats.drain.Drained, stats.drain.Killed)
+
+	if len(s.proxyTrusted) > 0 {
+		s.logger.Infof("PROXY protocol: rejected=%d", stats.proxyRejected)
+	}
+
+	labels := make([]string, 0, len(stats.listeners))
+	for label := range stats.listeners {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		st := stats.listeners[label]
+		s.logger.Infof("Listener %s: connections=%d requests=%d dropped=%d", label, st.connections, st.requestsHandled, st.dropped)
+	}
+}
+
+// latencyQuantile estimates the q-quantile of the durations of the phase
+func (s *TCPServer) latencyQuantile(phase string, q float64) time.Duration {
+	return time.Duration(s.latencies.Quantile(q, phase) * float64(time.Second)).Round(time.Microsecond)
+}
+
+// clientHost returns the host part of the connection's remote address,
+// which identifies the client for reputation purposes
+func clientHost(conn net.Conn) string {
+	return addrHost(conn.RemoteAddr())
+}
+
+// remoteAddr describes the remote side of the connection for logs,
+// Unix socket peers have no address of their own
+func remoteAddr(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil || addr.String() == "" || addr.String() == "@" {
+		return "local peer"
+	}
+
+	return addr.String()
 }
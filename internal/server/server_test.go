@@ -2,6 +2,8 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
@@ -10,7 +12,11 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/certs"
+	"github.com/zhashkevych/quotes-server/internal/proxyproto"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
 	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
 )
 
 type powMockBehavior func(m *mocks.MockProofOfWorkManager)
@@ -27,6 +33,7 @@ type testCase struct {
 	quoterMockBehavior quoterMockBehavior
 
 	verificationShouldFail bool
+	expectedErrorCode      string
 }
 
 func TestTCPServer_HandleConnection(t *testing.T) {
@@ -43,7 +50,7 @@ func TestTCPServer_HandleConnection(t *testing.T) {
 
 			},
 			quoterMockBehavior: func(m *mocks.MockQuoter, response string) {
-				m.EXPECT().GetRandomQuote().Return(response)
+				m.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quotes.Parse(response), nil)
 			},
 			verificationShouldFail: false,
 		},
@@ -60,18 +67,33 @@ func TestTCPServer_HandleConnection(t *testing.T) {
 			},
 			quoterMockBehavior:     func(m *mocks.MockQuoter, response string) {},
 			verificationShouldFail: true,
+			expectedErrorCode:      protocol.ErrCodeWrongSolution,
+		},
+		{
+			description:      "Malformed nonce",
+			powDifficulty:    4,
+			challenge:        "123456789",
+			nonce:            "4two",
+			expectedResponse: "",
+			powMockBehavior: func(m *mocks.MockProofOfWorkManager) {
+				m.EXPECT().GenerateChallenge(4).Return("123456789", nil)
+			},
+			quoterMockBehavior:     func(m *mocks.MockQuoter, response string) {},
+			verificationShouldFail: true,
+			expectedErrorCode:      protocol.ErrCodeBadFormat,
 		},
 		{
 			description:      "Request exceeds limit size",
 			powDifficulty:    4,
 			challenge:        "123456789",
-			nonce:            strings.Repeat("a", maxRequestSize+1),
-			expectedResponse: "Request data too large. Limit is 1024 bytes.\n",
+			nonce:            strings.Repeat("a", defaultMaxRequestSize+1),
+			expectedResponse: "",
 			powMockBehavior: func(m *mocks.MockProofOfWorkManager) {
 				m.EXPECT().GenerateChallenge(4).Return("123456789", nil)
 			},
 			quoterMockBehavior:     func(m *mocks.MockQuoter, response string) {},
 			verificationShouldFail: true,
+			expectedErrorCode:      protocol.ErrCodeTooLarge,
 		},
 	}
 
@@ -115,7 +137,9 @@ func TestTCPServer_HandleConnection(t *testing.T) {
 			response, err := reader.ReadString('\n')
 			assert.NoError(t, err)
 			if tc.verificationShouldFail {
-				assert.Equal(t, IncorrectSolutionResonse+"\n", response)
+				msg, ok := protocol.ParseTextError(response)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedErrorCode, msg.Code)
 			} else {
 				assert.Equal(t, tc.expectedResponse+"\n", response)
 			}
@@ -124,7 +148,527 @@ func TestTCPServer_HandleConnection(t *testing.T) {
 	}
 }
 
+func TestTCPServer_KeepAliveSession(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	gomock.InOrder(
+		powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil),
+		powManager.EXPECT().VerifySolution("greeting", 1).Return(true, nil),
+		powManager.EXPECT().GenerateChallenge(4).Return("second", nil),
+		powManager.EXPECT().VerifySolution("second", 2).Return(true, nil),
+	)
+	quoter.EXPECT().Categories().Return([]string{"life", "wisdom"})
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{Author: "Aristotle"}).Return(quote, nil).Times(3)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{Author: "Plato"}).Return(quotes.Quote{}, quotes.ErrNotFound)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithKeepAlive(GatePerCredit, 2))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	exchange := func(request string, expected ...string) {
+		fmt.Fprintln(conn, request)
+		for _, line := range expected {
+			response, err := reader.ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, line+"\n", response)
+		}
+	}
+
+	greeting, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "greeting\n", greeting)
+
+	exchange(protocol.CommandPing, PongResponse)
+	exchange(protocol.CommandCategories, "life,wisdom")
+	exchange("QUOTE 11", protocol.ErrCodeBadFormat+" "+InvalidArgumentResponse)
+	exchange("QUOTE author=Plato", protocol.ErrCodeNotFound+" "+QuoteNotFoundResponse)
+	// the greeting challenge pays for the first two quotes
+	exchange("QUOTE author=Aristotle", protocol.ChallengePrefix+" greeting")
+	exchange("1", quote.String())
+	exchange("QUOTE author=Aristotle", quote.String())
+	exchange("quote 1 author=Aristotle", protocol.ChallengePrefix+" second")
+	exchange("2", quote.String())
+	exchange("HELLO", protocol.ErrCodeUnknownCommand+" "+UnknownCommandResponse)
+	exchange(protocol.CommandQuit, ByeResponse)
+
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestTCPServer_JSONMode(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{ID: 6, Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle", Tags: []string{"wisdom"}}
+
+	gomock.InOrder(
+		powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil),
+		powManager.EXPECT().VerifySolution("greeting", 1).Return(false, nil),
+		powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil),
+		powManager.EXPECT().VerifySolution("greeting", 2).Return(true, nil),
+	)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil).Times(2)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithKeepAlive(GatePerCommand, 0))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	fmt.Fprintf(conn, "%s %s\n", protocol.NegotiateCommand, protocol.ModeJSON)
+
+	codec := protocol.NewJSONCodec(reader, conn)
+	exchange := func(request *protocol.Message, expected ...protocol.Message) {
+		if request != nil {
+			assert.NoError(t, codec.Write(*request))
+		}
+		for _, msg := range expected {
+			response, err := codec.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, msg, response)
+		}
+	}
+
+	challenge := protocol.Message{Type: protocol.TypeChallenge, Challenge: "greeting", Difficulty: 4}
+	wireQuote := protocol.Message{Type: protocol.TypeQuote, Quote: &protocol.Quote{ID: 6, Text: quote.Text, Author: quote.Author, Tags: quote.Tags}}
+
+	exchange(nil, challenge)
+	exchange(&protocol.Message{Type: protocol.TypeCommand, Command: protocol.CommandPing}, protocol.Message{Type: protocol.TypePong})
+	exchange(&protocol.Message{Type: protocol.TypeSolution, Nonce: 1},
+		protocol.NewError(protocol.ErrCodeBadFormat, UnexpectedMessageResonse))
+	exchange(&protocol.Message{Type: protocol.TypeCommand, Command: protocol.CommandQuote}, challenge)
+	exchange(&protocol.Message{Type: protocol.TypeSolution, Nonce: 1},
+		protocol.NewError(protocol.ErrCodeWrongSolution, IncorrectSolutionResonse))
+
+	// a failed solution ends the connection
+	_, err = codec.Read()
+	assert.Error(t, err)
+
+	// the classic exchange works in JSON mode too
+	conn, err = net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader = bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	fmt.Fprintf(conn, "%s %s\n", protocol.NegotiateCommand, protocol.ModeJSON)
+
+	codec = protocol.NewJSONCodec(reader, conn)
+	exchange(nil, challenge)
+	exchange(&protocol.Message{Type: protocol.TypeSolution, Nonce: 2}, wireQuote)
+}
+
+func TestTCPServer_BinaryMode(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{ID: 7, Text: "First line\nsecond line", Author: "Anonymous"}
+
+	gomock.InOrder(
+		powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil),
+		powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil),
+		powManager.EXPECT().VerifySolution("greeting", 42).Return(true, nil),
+	)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithKeepAlive(GatePerCommand, 0))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	dial := func() (net.Conn, protocol.Codec) {
+		conn, err := net.Dial("tcp", server.getAddr())
+		assert.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		_, err = reader.ReadString('\n')
+		assert.NoError(t, err)
+
+		fmt.Fprintf(conn, "%s %s\n", protocol.NegotiateCommand, protocol.ModeBinary)
+
+		codec := protocol.NewBinaryCodec(reader, conn)
+
+		msg, err := codec.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, protocol.Message{Type: protocol.TypeChallenge, Challenge: "greeting", Difficulty: 4}, msg)
+
+		return conn, codec
+	}
+
+	// an oversized frame is rejected by its announced length
+	conn, codec := dial()
+	defer conn.Close()
+
+	_, err := conn.Write([]byte{0x03, 0, 1, 0, 0})
+	assert.NoError(t, err)
+
+	msg, err := codec.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.TypeError, msg.Type)
+	assert.Equal(t, protocol.ErrCodeTooLarge, msg.Code)
+
+	_, err = codec.Read()
+	assert.Error(t, err)
+
+	// multi-line quotes survive the framing
+	conn, codec = dial()
+	defer conn.Close()
+
+	assert.NoError(t, codec.Write(protocol.Message{Type: protocol.TypeSolution, Nonce: 42}))
+
+	msg, err = codec.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.Message{Type: protocol.TypeQuote, Quote: &protocol.Quote{ID: 7, Text: quote.Text, Author: quote.Author}}, msg)
+}
+
+func TestTCPServer_SolutionAttempts(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	reputation := mocks.NewMockReputationTracker(c)
+
+	quote := quotes.Quote{Text: "Patience is the companion of wisdom.", Author: "Saint Augustine"}
+
+	gomock.InOrder(
+		powManager.EXPECT().GenerateChallenge(4).Return("123456789", nil),
+		powManager.EXPECT().VerifySolution("123456789", 41).Return(false, nil),
+		powManager.EXPECT().VerifySolution("123456789", 42).Return(true, nil),
+		powManager.EXPECT().GenerateChallenge(4).Return("987654321", nil),
+		powManager.EXPECT().VerifySolution("987654321", 1).Return(false, nil),
+		powManager.EXPECT().VerifySolution("987654321", 2).Return(false, nil),
+	)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+	reputation.EXPECT().IsBanned(gomock.Any()).Return(false).Times(2)
+	reputation.EXPECT().RecordFailure(gomock.Any()).Times(5)
+	reputation.EXPECT().RecordSuccess(gomock.Any())
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithSolutionAttempts(3), WithReputation(reputation))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	exchange := func(conn net.Conn, reader *bufio.Reader, request string, expected string) {
+		fmt.Fprintln(conn, request)
+		response, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, expected+"\n", response)
+	}
+
+	// a mistyped and a wrong nonce don't end the connection
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	exchange(conn, reader, "4l", protocol.ErrCodeBadFormat+" "+InvalidSolutionResponse)
+	exchange(conn, reader, "41", protocol.ErrCodeWrongSolution+" "+IncorrectSolutionResonse)
+	exchange(conn, reader, "42", quote.String())
+
+	// the attempts run out
+	conn, err = net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader = bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	exchange(conn, reader, "1", protocol.ErrCodeWrongSolution+" "+IncorrectSolutionResonse)
+	exchange(conn, reader, "2", protocol.ErrCodeWrongSolution+" "+IncorrectSolutionResonse)
+	exchange(conn, reader, "two", protocol.ErrCodeBadFormat+" "+InvalidSolutionResponse)
+
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+
+	assert.Equal(t, 5, server.stats().failedAttempts)
+}
+
+func TestTCPServer_Banned(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	reputation := mocks.NewMockReputationTracker(c)
+
+	reputation.EXPECT().IsBanned(gomock.Any()).Return(true)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithReputation(reputation))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeRateLimited+" "+BannedResponse+"\n", response)
+}
+
+func TestTCPServer_ProxyProtocol(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	reputation := mocks.NewMockReputationTracker(c)
+
+	// the client behind the balancer is banned, not the balancer
+	reputation.EXPECT().IsBanned("203.0.113.7").Return(true)
+
+	trusted, err := proxyproto.ParseCIDRs("127.0.0.1,::1")
+	assert.NoError(t, err)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithReputation(reputation), WithProxyProtocol(trusted),
+		WithTimeouts(Timeouts{Handshake: 200 * time.Millisecond}))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	fmt.Fprint(conn, "PROXY TCP4 203.0.113.7 192.0.2.1 56324 9000\r\n")
+
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeRateLimited+" "+BannedResponse+"\n", response)
+
+	// a trusted source must send a header
+	conn, err = net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	fmt.Fprint(conn, "42\n")
+
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	assert.Error(t, err)
+
+	server.Shutdown(context.Background())
+	assert.Equal(t, 1, server.stats().proxyRejected)
+}
+
+func TestTCPServer_Timeouts(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil).Times(2)
+
+	timeouts := Timeouts{Handshake: 100 * time.Millisecond, SolveBase: 200 * time.Millisecond, SolveMax: 200 * time.Millisecond}
+	server := NewTCPServer(0, 4, quoter, powManager, WithKeepAlive(GatePerCommand, 1), WithTimeouts(timeouts))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	// the greeting challenge isn't solved within the solve window
+	conn, err := net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	response, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeExpired+" "+ExpiredResponse+"\n", response)
+
+	// an idle session waits for the next command no longer than the handshake timeout
+	conn, err = net.Dial("tcp", server.getAddr())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader = bufio.NewReader(conn)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	fmt.Fprintln(conn, protocol.CommandPing)
+	response, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, PongResponse+"\n", response)
+
+	response, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.ErrCodeTimeout+" "+TimeoutResponse+"\n", response)
+
+	stats := server.stats()
+	assert.Equal(t, 1, stats.timeouts[phaseSolve])
+	assert.Equal(t, 1, stats.timeouts[phaseHandshake])
+}
+
+func TestTCPServer_TLS(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("123456789", nil)
+	powManager.EXPECT().VerifySolution("123456789", 42).Return(true, nil)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil)
+
+	serverCert, serverKey, err := certs.WriteSelfSigned(t.TempDir(), []string{"localhost"}, time.Hour)
+	assert.NoError(t, err)
+	clientCert, clientKey, err := certs.WriteSelfSigned(t.TempDir(), []string{"client"}, time.Hour)
+	assert.NoError(t, err)
+
+	tlsConfig, _, err := certs.NewServerConfig(certs.Config{CertFile: serverCert, KeyFile: serverKey, CAFile: clientCert})
+	assert.NoError(t, err)
+
+	server := NewTCPServer(0, 4, quoter, powManager, WithTLS(tlsConfig))
+
+	go func() {
+		err := server.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	time.Sleep(time.Second) // wait for the server initialization inside goroutine
+
+	// a client without a certificate doesn't get a challenge
+	clientConfig, err := certs.NewClientConfig(certs.Config{CAFile: serverCert, ServerName: "localhost"})
+	assert.NoError(t, err)
+
+	conn, err := tls.Dial("tcp", server.getAddr(), clientConfig)
+	if err == nil {
+		_, err = bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+	}
+	assert.Error(t, err)
+
+	clientConfig, err = certs.NewClientConfig(certs.Config{CertFile: clientCert, KeyFile: clientKey, CAFile: serverCert, ServerName: "localhost"})
+	assert.NoError(t, err)
+
+	conn, err = tls.Dial("tcp", server.getAddr(), clientConfig)
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	challenge, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789\n", challenge)
+
+	fmt.Fprintln(conn, 42)
+
+	response, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, quote.String()+"\n", response)
+}
+
+func TestTimeouts_SolveWindow(t *testing.T) {
+	timeouts := Timeouts{SolveBase: 5 * time.Second, SolveHashRate: 1 << 16, SolveMax: time.Minute}
+
+	assert.Equal(t, 5*time.Second+time.Second/16, timeouts.solveWindow(3))
+	assert.Equal(t, 6*time.Second, timeouts.solveWindow(4))
+	assert.Equal(t, 21*time.Second, timeouts.solveWindow(5))
+	assert.Equal(t, time.Minute, timeouts.solveWindow(6))
+	assert.Equal(t, time.Minute, timeouts.solveWindow(64))
+}
+
+func TestParseQuoteArgs(t *testing.T) {
+	tests := []struct {
+		args       string
+		count      int
+		filter     quotes.Filter
+		shouldFail bool
+	}{
+		{args: "", count: 1},
+		{args: "3", count: 3},
+		{args: "author=Albert Einstein", count: 1, filter: quotes.Filter{Author: "Albert Einstein"}},
+		{args: "2 category=wisdom", count: 2, filter: quotes.Filter{Category: "wisdom"}},
+		{args: "0", shouldFail: true},
+		{args: "11", shouldFail: true},
+		{args: "author=", shouldFail: true},
+		{args: "year=1900", shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.args, func(t *testing.T) {
+			count, filter, err := parseQuoteArgs(tc.args)
+			if tc.shouldFail {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.count, count)
+			assert.Equal(t, tc.filter, filter)
+		})
+	}
+}
+
 // used for testing
 func (s *TCPServer) getAddr() string {
-	return s.listener.Addr().String()
+	<-s.Ready()
+	return s.listeners[0].Addr().String()
 }
@@ -0,0 +1,603 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+const (
+	PongResponse             = "PONG"
+	ByeResponse              = "BYE"
+	UnknownCommandResponse   = "Unknown command"
+	InvalidArgumentResponse  = "Invalid argument"
+	UnexpectedMessageResonse = "Unexpected message"
+)
+
+const maxQuotesPerCommand = 10
+
+// PowGate selects what a solved challenge pays for in a command session
+type PowGate int
+
+const (
+	// GatePerCommand requires a fresh solution for every quote command
+	GatePerCommand PowGate = iota
+	// GatePerCredit buys Settings.CreditsPerSolution quotes with every solution
+	GatePerCredit
+)
+
+// session serves a single connection after the greeting challenge has been sent.
+// A solution to the greeting challenge is answered with a single quote, while commands
+// switch the connection into a keep-alive session where quotes are paid for with credits
+// earned by solving challenges.
+type session struct {
+	server    *TCPServer
+	config    *LiveConfig
+	listener  *listener
+	state     *connState
+	conn      net.Conn
+	reader    *bufio.Reader
+	codec     protocol.Codec
+	startTime time.Time
+	info      ConnInfo
+	access    *accessRecord
+
+	// client identifies the remote side for reputation purposes
+	client string
+
+	// challenge is the issued challenge that hasn't been solved yet
+	challenge string
+	// challengeDifficulty is the difficulty of the pending challenge, as the hooks left it
+	challengeDifficulty int
+	// challengeDeadline is when the client's time to solve the challenge runs out
+	challengeDeadline time.Time
+	// challengeSentAt is when the pending challenge was sent by the server clock
+	challengeSentAt time.Time
+	credits         int
+}
+
+func (s *TCPServer) newSession(conn net.Conn, l *listener, state *connState, config *LiveConfig, info ConnInfo, access *accessRecord) *session {
+	// the buffer size bounds the length of a text line
+	reader := bufio.NewReaderSize(conn, config.Settings.MaxRequestSize)
+
+	return &session{
+		server:    s,
+		config:    config,
+		listener:  l,
+		state:     state,
+		conn:      conn,
+		reader:    reader,
+		codec:     newTextCodec(reader, conn),
+		startTime: info.Accepted,
+		info:      info,
+		access:    access,
+		client:    info.Client,
+	}
+}
+
+// serve sends the greeting challenge, handles the client's reply and, for command sessions,
+// reads commands until the client quits, fails a challenge or the connection breaks
+func (ss *session) serve() {
+	if !ss.issueChallenge() {
+		return
+	}
+
+	// the greeting is a bare challenge line that every client understands
+	start := ss.server.now()
+	ss.setWriteDeadline()
+	if _, err := fmt.Fprintf(ss.conn, "%s\n", ss.challenge); err != nil {
+		ss.checkWriteError(err)
+		return
+	}
+	ss.challengeSent(start)
+
+	// the reply may be a solution, so the client gets the whole solve window for it
+	msg, err := ss.readSolution()
+	if err != nil {
+		ss.reportReadError(err)
+		return
+	}
+
+	if msg.Type == protocol.TypeCommand && msg.Command == protocol.NegotiateCommand {
+		if !ss.negotiate(msg.Args) {
+			return
+		}
+
+		var err error
+		if msg, err = ss.readSolution(); err != nil {
+			ss.reportReadError(err)
+			return
+		}
+	}
+
+	// the classic exchange: a single quote for the greeting challenge
+	if msg.Type == protocol.TypeSolution || !ss.config.Settings.KeepAlive {
+		if !ss.redeem(msg) {
+			return
+		}
+
+		list, err := ss.pickQuotes(1, quotes.Filter{})
+		if err != nil {
+			ss.writeQuoteError(err)
+			return
+		}
+
+		if ss.writeQuotes(list) {
+			ss.server.collectMetrics(ss.listener.label, ss.startTime)
+		}
+		return
+	}
+
+	ss.server.logger.Debugf("session started for %s", remoteAddr(ss.conn))
+
+	for ss.handle(msg) {
+		var err error
+		msg, err = ss.readCommand()
+		if err != nil {
+			// a malformed message is skipped, anything else ends the session
+			if ss.reportReadError(err) && errors.Is(err, protocol.ErrMalformed) {
+				continue
+			}
+			return
+		}
+	}
+}
+
+// negotiate switches the connection to the requested protocol mode and resends the greeting challenge in it
+func (ss *session) negotiate(mode string) bool {
+	switch strings.ToLower(mode) {
+	case protocol.ModeText:
+	case protocol.ModeJSON:
+		ss.codec = protocol.NewJSONCodec(ss.reader, ss.conn)
+	case protocol.ModeBinary:
+		ss.codec = protocol.NewBinaryCodec(ss.reader, ss.conn)
+	default:
+		ss.writeError(protocol.ErrCodeBadFormat, InvalidArgumentResponse)
+		return false
+	}
+
+	return ss.sendChallenge()
+}
+
+// handle executes a single session message and reports whether the session should go on
+func (ss *session) handle(msg protocol.Message) bool {
+	startTime := ss.server.now()
+
+	if msg.Type != protocol.TypeCommand {
+		return ss.writeError(protocol.ErrCodeBadFormat, UnexpectedMessageResonse)
+	}
+
+	switch strings.ToUpper(msg.Command) {
+	case protocol.CommandQuote:
+		count, filter, err := parseQuoteArgs(msg.Args)
+		if err != nil {
+			return ss.writeError(protocol.ErrCodeBadFormat, InvalidArgumentResponse)
+		}
+		return ss.quote(startTime, count, filter)
+	case protocol.CommandCategories:
+		return ss.write(protocol.Message{
+			Type:       protocol.TypeCategories,
+			Categories: ss.config.Quotes.Categories(),
+		})
+	case protocol.CommandPing:
+		return ss.write(protocol.Message{Type: protocol.TypePong})
+	case protocol.CommandStats:
+		stats := ss.server.stats()
+		return ss.write(protocol.Message{
+			Type: protocol.TypeStats,
+			Stats: &protocol.Stats{
+				RequestsHandled:       stats.requestsHandled,
+				AverageResponseTimeMs: float64(stats.averageResponseTime) / float64(time.Millisecond),
+				FailedAttempts:        stats.failedAttempts,
+				Timeouts:              stats.totalTimeouts(),
+				Credits:               ss.credits,
+			},
+		})
+	case protocol.CommandQuit:
+		ss.write(protocol.Message{Type: protocol.TypeBye})
+		return false
+	default:
+		return ss.writeError(protocol.ErrCodeUnknownCommand, UnknownCommandResponse)
+	}
+}
+
+func (ss *session) quote(startTime time.Time, count int, filter quotes.Filter) bool {
+	// pick the quotes first so that a filter without matches doesn't cost anything
+	list, err := ss.pickQuotes(count, filter)
+	if err != nil {
+		return ss.writeQuoteError(err)
+	}
+
+	if !ss.charge(count) {
+		return false
+	}
+
+	if !ss.writeQuotes(list) {
+		return false
+	}
+
+	ss.server.collectMetrics(ss.listener.label, startTime)
+
+	return true
+}
+
+func (ss *session) pickQuotes(count int, filter quotes.Filter) ([]quotes.Quote, error) {
+	list := make([]quotes.Quote, 0, count)
+	for i := 0; i < count; i++ {
+		quote, err := ss.config.Quotes.GetRandomQuote(filter)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, quote)
+	}
+
+	return list, nil
+}
+
+func (ss *session) writeQuotes(list []quotes.Quote) bool {
+	start := ss.server.now()
+	for _, quote := range list {
+		if err := ss.server.hooks.beforeQuote(ss.info, &quote); err != nil {
+			return ss.reject(err)
+		}
+
+		if !ss.write(protocol.Message{
+			Type: protocol.TypeQuote,
+			Quote: &protocol.Quote{
+				ID:     quote.ID,
+				Text:   quote.Text,
+				Author: quote.Author,
+				Tags:   quote.Tags,
+			},
+		}) {
+			return false
+		}
+		ss.server.metrics.QuoteServed(ss.listener.label)
+	}
+	ss.server.collectLatency(latencyQuoteSend, ss.server.now().Sub(start))
+
+	return true
+}
+
+func (ss *session) writeQuoteError(err error) bool {
+	if err == quotes.ErrNotFound {
+		return ss.writeError(protocol.ErrCodeNotFound, QuoteNotFoundResponse)
+	}
+
+	return ss.writeError(protocol.ErrCodeInternal, InternalServerErrorResponse)
+}
+
+// charge takes the price of count quotes from the session credits,
+// issuing challenges until the client can afford it
+func (ss *session) charge(count int) bool {
+	settings := ss.config.Settings
+
+	cost, reward := 1, 1
+	if settings.PowGate == GatePerCredit {
+		cost, reward = count, settings.CreditsPerSolution
+	}
+
+	if ss.listener.policy.SkipPoW {
+		return true
+	}
+
+	for ss.credits < cost {
+		if !ss.solveChallenge() {
+			return false
+		}
+		ss.credits += reward
+	}
+
+	ss.credits -= cost
+
+	return true
+}
+
+// solveChallenge sends the pending challenge (issuing a new one if needed)
+// and verifies the client's solutions
+func (ss *session) solveChallenge() bool {
+	if ss.challenge == "" && !ss.issueChallenge() {
+		return false
+	}
+
+	if !ss.sendChallenge() {
+		return false
+	}
+
+	msg, err := ss.readSolution()
+	if err != nil && !errors.Is(err, protocol.ErrMalformed) {
+		ss.reportReadError(err)
+		return false
+	}
+
+	return ss.redeem(msg)
+}
+
+// sendChallenge sends the pending challenge and gives the client the full solve window for it
+func (ss *session) sendChallenge() bool {
+	start := ss.server.now()
+	if !ss.write(protocol.Message{
+		Type:       protocol.TypeChallenge,
+		Challenge:  ss.challenge,
+		Difficulty: ss.challengeDifficulty,
+	}) {
+		return false
+	}
+
+	ss.challengeSent(start)
+
+	return true
+}
+
+// challengeSent times sending the pending challenge since start and starts its solve window
+func (ss *session) challengeSent(start time.Time) {
+	ss.challengeSentAt = ss.server.now()
+	ss.server.collectLatency(latencyChallengeSend, ss.challengeSentAt.Sub(start))
+
+	ss.startSolveWindow()
+	ss.server.hooks.afterChallenge(ss.info, ss.challenge, ss.challengeDifficulty)
+}
+
+// issueChallenge generates the pending challenge at the difficulty the hooks agree on
+func (ss *session) issueChallenge() bool {
+	difficulty, err := ss.server.hooks.beforeChallenge(ss.info, ss.difficulty())
+	if err != nil {
+		return ss.reject(err)
+	}
+
+	challenge, err := ss.server.powManager.GenerateChallenge(difficulty)
+	if err != nil {
+		ss.writeError(protocol.ErrCodeInternal, InternalServerErrorResponse)
+		return false
+	}
+	ss.server.metrics.ChallengeIssued(ss.listener.label)
+	ss.challenge, ss.challengeDifficulty = challenge, difficulty
+	ss.access.difficulty = difficulty
+
+	return true
+}
+
+// difficulty returns the difficulty of the challenges issued in the session before the hooks
+func (ss *session) difficulty() int {
+	if ss.listener.policy.SkipPoW {
+		return 0
+	}
+
+	return ss.config.PowDifficulty
+}
+
+// startSolveWindow sets the deadline of the pending challenge according to its difficulty.
+// Deadlines follow the system clock, the server clock only times the exchange.
+func (ss *session) startSolveWindow() {
+	window := ss.config.Settings.Timeouts.solveWindow(ss.challengeDifficulty)
+	ss.challengeDeadline = time.Now().Add(window)
+}
+
+// redeem checks the client's solutions of the pending challenge until one is correct,
+// the attempts run out or the challenge expires
+func (ss *session) redeem(msg protocol.Message) bool {
+	for attempt := 1; ; attempt++ {
+		if msg.Type == protocol.TypeSolution {
+			solveDuration := ss.server.now().Sub(ss.challengeSentAt)
+			ss.server.collectLatency(latencySolve, solveDuration)
+			ss.access.solveDuration += solveDuration
+
+			if err := ss.server.hooks.solution(ss.info, ss.challenge, msg.Nonce); err != nil {
+				return ss.reject(err)
+			}
+		}
+
+		if ss.checkSolution(msg) {
+			return true
+		}
+
+		if attempt >= ss.config.Settings.SolutionAttempts {
+			return false
+		}
+
+		var err error
+		msg, err = ss.readSolution()
+		if err != nil && !errors.Is(err, protocol.ErrMalformed) {
+			ss.reportReadError(err)
+			return false
+		}
+	}
+}
+
+// checkSolution verifies a single solution attempt, counting failures toward the client's reputation
+func (ss *session) checkSolution(msg protocol.Message) bool {
+	if msg.Type != protocol.TypeSolution {
+		ss.recordFailure(rejectedMalformed)
+		ss.writeError(protocol.ErrCodeBadFormat, InvalidSolutionResponse)
+		return false
+	}
+
+	ss.server.logger.Debugf("received solution: %d", msg.Nonce)
+
+	start := ss.server.now()
+	isValid, err := ss.server.powManager.VerifySolution(ss.challenge, msg.Nonce)
+	ss.server.collectLatency(latencyVerify, ss.server.now().Sub(start))
+	ss.server.hooks.verification(ss.info, ss.challenge, msg.Nonce, err == nil && isValid)
+	if err != nil || !isValid {
+		ss.recordFailure(rejectedWrong)
+		ss.writeError(protocol.ErrCodeWrongSolution, IncorrectSolutionResonse)
+		return false
+	}
+
+	ss.challenge = ""
+	ss.server.reputation.RecordSuccess(ss.client)
+	ss.server.metrics.SolutionAccepted(ss.listener.label)
+
+	return true
+}
+
+func (ss *session) recordFailure(reason string) {
+	ss.server.collectFailedAttempt(ss.listener.label, reason)
+	ss.server.reputation.RecordFailure(ss.client)
+}
+
+// timeoutError reports a deadline exceeded in a particular phase of the exchange
+type timeoutError struct {
+	phase phase
+	err   error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s timeout: %s", e.phase, e.err)
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+// readSolution reads a message within the solve window of the pending challenge
+func (ss *session) readSolution() (protocol.Message, error) {
+	return ss.readUntil(phaseSolve, ss.challengeDeadline)
+}
+
+// readCommand waits for the next command of a session,
+// the wait is cut short with errDraining when the server starts draining
+func (ss *session) readCommand() (protocol.Message, error) {
+	deadline := time.Now().Add(ss.config.Settings.Timeouts.Handshake)
+	if err := ss.state.enterIdle(ss.conn, deadline); err != nil {
+		return protocol.Message{}, err
+	}
+
+	msg, err := ss.read(phaseHandshake)
+	ss.state.leaveIdle()
+
+	var timeoutErr *timeoutError
+	if errors.As(err, &timeoutErr) && ss.state.isDraining() {
+		return msg, errDraining
+	}
+
+	return msg, err
+}
+
+func (ss *session) readUntil(p phase, deadline time.Time) (protocol.Message, error) {
+	if err := ss.conn.SetReadDeadline(deadline); err != nil {
+		return protocol.Message{}, err
+	}
+
+	return ss.read(p)
+}
+
+// read reads a message within the read deadline of the connection
+func (ss *session) read(p phase) (protocol.Message, error) {
+	msg, err := ss.codec.Read()
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if !ss.state.isDraining() {
+			ss.server.collectTimeout(p)
+		}
+		return msg, &timeoutError{phase: p, err: err}
+	}
+
+	return msg, err
+}
+
+// reportReadError tells the client about a message it sent that couldn't be decoded
+// and reports whether the connection is still usable
+func (ss *session) reportReadError(err error) bool {
+	var timeoutErr *timeoutError
+
+	switch {
+	case errors.Is(err, protocol.ErrMalformed):
+		return ss.writeError(protocol.ErrCodeBadFormat, err.Error())
+	case errors.Is(err, protocol.ErrTooLarge):
+		ss.writeError(protocol.ErrCodeTooLarge, err.Error())
+	case errors.Is(err, errDraining):
+		ss.writeError(protocol.ErrCodeShuttingDown, ShuttingDownResponse)
+	case errors.Is(err, ErrSlowClient):
+		ss.writeError(protocol.ErrCodeTimeout, SlowClientResponse)
+	case errors.As(err, &timeoutErr) && timeoutErr.phase == phaseSolve:
+		ss.writeError(protocol.ErrCodeExpired, ExpiredResponse)
+	case errors.As(err, &timeoutErr):
+		ss.writeError(protocol.ErrCodeTimeout, TimeoutResponse)
+	}
+
+	return false
+}
+
+func (ss *session) write(msg protocol.Message) bool {
+	ss.setWriteDeadline()
+
+	if err := ss.codec.Write(msg); err != nil {
+		ss.checkWriteError(err)
+		return false
+	}
+	ss.access.sent(msg)
+
+	return true
+}
+
+func (ss *session) setWriteDeadline() {
+	ss.conn.SetWriteDeadline(time.Now().Add(ss.config.Settings.Timeouts.Write))
+}
+
+// checkWriteError counts a client that doesn't read its responses in time
+func (ss *session) checkWriteError(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		ss.server.collectTimeout(phaseWrite)
+	}
+}
+
+func (ss *session) writeError(code, text string) bool {
+	return ss.write(protocol.NewError(code, text))
+}
+
+// reject ends the session on an error of a hook
+func (ss *session) reject(err error) bool {
+	ss.server.logger.Infof("connection from %s is rejected: %s", remoteAddr(ss.conn), err)
+	ss.write(rejection(err))
+
+	return false
+}
+
+// parseQuoteArgs parses "[<n>] [author=<name>|category=<name>]"
+func parseQuoteArgs(args string) (int, quotes.Filter, error) {
+	count := 1
+	filter := quotes.Filter{}
+
+	if args == "" {
+		return count, filter, nil
+	}
+
+	first, rest, _ := strings.Cut(args, " ")
+	if n, err := strconv.Atoi(first); err == nil {
+		if n < 1 || n > maxQuotesPerCommand {
+			return 0, filter, fmt.Errorf("quotes count must be between 1 and %d", maxQuotesPerCommand)
+		}
+		count, args = n, strings.TrimSpace(rest)
+	}
+
+	if args == "" {
+		return count, filter, nil
+	}
+
+	key, value, ok := strings.Cut(args, "=")
+	value = strings.TrimSpace(value)
+	if !ok || value == "" {
+		return 0, filter, fmt.Errorf("invalid quote filter %q", args)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "author":
+		filter.Author = value
+	case "category":
+		filter.Category = value
+	default:
+		return 0, filter, fmt.Errorf("unknown quote filter %q", key)
+	}
+
+	return count, filter, nil
+}
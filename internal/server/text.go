@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// textCodec is the server side of the line based text protocol
+type textCodec struct {
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+func newTextCodec(reader *bufio.Reader, writer io.Writer) *textCodec {
+	return &textCodec{reader: reader, writer: writer}
+}
+
+func (c *textCodec) Read() (protocol.Message, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return protocol.Message{}, errors.Wrapf(protocol.ErrTooLarge, "line exceeds %d bytes", c.reader.Size())
+		}
+		return protocol.Message{}, err
+	}
+
+	return parseTextLine(string(line)), nil
+}
+
+func (c *textCodec) Write(msg protocol.Message) error {
+	var line string
+
+	switch msg.Type {
+	case protocol.TypeChallenge:
+		line = fmt.Sprintf("%s %s", protocol.ChallengePrefix, msg.Challenge)
+	case protocol.TypeQuote:
+		line = quotes.Quote{Text: msg.Quote.Text, Author: msg.Quote.Author}.String()
+	case protocol.TypeCategories:
+		line = strings.Join(msg.Categories, ",")
+	case protocol.TypeStats:
+		averageResponseTime := time.Duration(msg.Stats.AverageResponseTimeMs * float64(time.Millisecond))
+		line = fmt.Sprintf("requests=%d avg_response_time=%s failed_attempts=%d timeouts=%d credits=%d",
+			msg.Stats.RequestsHandled, averageResponseTime, msg.Stats.FailedAttempts, msg.Stats.Timeouts, msg.Stats.Credits)
+	case protocol.TypePong:
+		line = PongResponse
+	case protocol.TypeBye:
+		line = ByeResponse
+	case protocol.TypeError:
+		line = fmt.Sprintf("%s %s", msg.Code, msg.Error)
+	default:
+		return fmt.Errorf("message type %q is not supported in text mode", msg.Type)
+	}
+
+	_, err := fmt.Fprintf(c.writer, "%s\n", line)
+	return err
+}
+
+// parseTextLine treats a number as a solution and anything else as a command
+func parseTextLine(line string) protocol.Message {
+	line = strings.TrimSpace(line)
+
+	if nonce, err := strconv.Atoi(line); err == nil {
+		return protocol.Message{Type: protocol.TypeSolution, Nonce: nonce}
+	}
+
+	name, args, _ := strings.Cut(line, " ")
+
+	return protocol.Message{
+		Type:    protocol.TypeCommand,
+		Command: strings.ToUpper(name),
+		Args:    strings.TrimSpace(args),
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// PadResponse asks the client to send a larger datagram to fit the response
+const PadResponse = "Pad the request to %d bytes"
+
+// UDPServer serves proof-of-work gated quotes in datagrams of the text mode.
+// A "QUOTE" datagram is answered with a signed challenge bound to the client address,
+// a "QUOTE <challenge> <nonce> [author=<name>|category=<name>]" datagram with a quote.
+// Anything after the first line of a datagram is padding.
+//
+// A response is never larger than the request that triggered it, so the server can't be used
+// to amplify traffic towards a spoofed address. A response that doesn't fit is replaced with
+// an ERR_TOO_LARGE error telling the size to pad the request to, or dropped.
+//
+// The server shares the quotes, the proof-of-work manager, the difficulty, the reputation
+// and the metrics of the TCP server.
+type UDPServer struct {
+	port   int
+	tcp    *TCPServer
+	signer *challenge.Signer
+
+	// connMutex guards the socket, Shutdown may run before or while Serve starts
+	connMutex sync.Mutex
+	conn      net.PacketConn
+	closed    bool
+}
+
+func NewUDPServer(port int, tcp *TCPServer, signer *challenge.Signer) *UDPServer {
+	return &UDPServer{port: port, tcp: tcp, signer: signer}
+}
+
+func (u *UDPServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", u.port))
+	if err != nil {
+		return err
+	}
+
+	return u.Serve(conn)
+}
+
+// Serve answers the datagrams of the socket, e.g. one passed by a parent process
+func (u *UDPServer) Serve(conn net.PacketConn) error {
+	u.connMutex.Lock()
+	if u.closed {
+		u.connMutex.Unlock()
+		return conn.Close()
+	}
+	u.conn = conn
+	u.connMutex.Unlock()
+	defer conn.Close()
+
+	u.tcp.logger.Infof("Starting UDP server at %s", conn.LocalAddr())
+
+	// the size is fixed for the socket, reloads don't change it
+	maxRequestSize := u.tcp.liveConfig().Settings.MaxRequestSize
+
+	// one byte more than allowed to tell an oversized datagram
+	buf := make([]byte, maxRequestSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			u.tcp.logger.Error("Error reading datagram:", err.Error())
+			continue
+		}
+
+		var response []byte
+		if n > maxRequestSize {
+			response = encodeText(protocol.NewError(protocol.ErrCodeTooLarge, fmt.Sprintf("datagram exceeds %d bytes", maxRequestSize)))
+		} else {
+			response = u.handle(buf[:n], addr)
+		}
+
+		if response = fitResponse(response, n); response == nil {
+			u.tcp.logger.Debugf("dropped response to %s: request of %d bytes is too small", addr, n)
+			continue
+		}
+
+		conn.WriteTo(response, addr)
+	}
+}
+
+func (u *UDPServer) Shutdown() {
+	u.connMutex.Lock()
+	defer u.connMutex.Unlock()
+
+	// a socket passed to Serve later is closed at once
+	u.closed = true
+	if u.conn != nil {
+		u.conn.Close()
+	}
+}
+
+// handle returns the response to a request datagram of the client at the address
+func (u *UDPServer) handle(request []byte, addr net.Addr) []byte {
+	startTime := u.tcp.now()
+	client := addrHost(addr)
+
+	info := u.tcp.requestInfo(udpListener, addr, client, startTime)
+	defer u.tcp.hooks.close(info)
+
+	if err := u.tcp.hooks.accept(info); err != nil {
+		return encodeText(rejection(err))
+	}
+
+	line, _, _ := bytes.Cut(request, []byte("\n"))
+	fields := strings.Fields(string(line))
+
+	if len(fields) == 0 || strings.ToUpper(fields[0]) != protocol.CommandQuote {
+		return encodeText(protocol.NewError(protocol.ErrCodeUnknownCommand, UnknownCommandResponse))
+	}
+
+	config := u.tcp.liveConfig()
+
+	if len(fields) == 1 {
+		difficulty, err := u.tcp.hooks.beforeChallenge(info, config.PowDifficulty)
+		if err != nil {
+			return encodeText(rejection(err))
+		}
+
+		plain, err := u.tcp.powManager.GenerateChallenge(difficulty)
+		if err != nil {
+			return encodeText(protocol.NewError(protocol.ErrCodeInternal, InternalServerErrorResponse))
+		}
+
+		u.tcp.metrics.ChallengeIssued(udpListener.label)
+
+		signed := u.signer.SignFor(plain, client, config.Settings.Timeouts.solveWindow(difficulty))
+		u.tcp.hooks.afterChallenge(info, signed, difficulty)
+
+		return encodeText(protocol.Message{Type: protocol.TypeChallenge, Challenge: signed, Difficulty: difficulty})
+	}
+
+	if len(fields) < 3 {
+		return encodeText(protocol.NewError(protocol.ErrCodeBadFormat, InvalidSolutionResponse))
+	}
+
+	signed := fields[1]
+
+	// the signature binds the challenge to the client address, failures of forged
+	// or spoofed requests are not held against the address
+	plain, _, err := u.signer.VerifyFor(signed, client)
+	switch {
+	case errors.Is(err, challenge.ErrExpired):
+		u.tcp.metrics.SolutionRejected(udpListener.label, rejectedExpired)
+		return encodeText(protocol.NewError(protocol.ErrCodeExpired, ExpiredResponse))
+	case err != nil:
+		u.tcp.metrics.SolutionRejected(udpListener.label, rejectedForged)
+		return encodeText(protocol.NewError(protocol.ErrCodeBadFormat, InvalidChallengeResponse))
+	}
+
+	nonce, err := strconv.Atoi(fields[2])
+	if err != nil {
+		u.recordFailure(client, rejectedMalformed)
+		return encodeText(protocol.NewError(protocol.ErrCodeBadFormat, InvalidSolutionResponse))
+	}
+
+	count, filter, err := parseQuoteArgs(strings.Join(fields[3:], " "))
+	if err != nil || count != 1 {
+		return encodeText(protocol.NewError(protocol.ErrCodeBadFormat, InvalidArgumentResponse))
+	}
+
+	if err := u.tcp.hooks.solution(info, signed, nonce); err != nil {
+		return encodeText(rejection(err))
+	}
+
+	isValid, err := u.tcp.powManager.VerifySolution(plain, nonce)
+	u.tcp.hooks.verification(info, signed, nonce, err == nil && isValid)
+	if err != nil || !isValid {
+		u.recordFailure(client, rejectedWrong)
+		return encodeText(protocol.NewError(protocol.ErrCodeWrongSolution, IncorrectSolutionResonse))
+	}
+
+	// pick the quote before redeeming the challenge so that a filter without matches doesn't cost anything
+	quote, err := config.Quotes.GetRandomQuote(filter)
+	if err == quotes.ErrNotFound {
+		return encodeText(protocol.NewError(protocol.ErrCodeNotFound, QuoteNotFoundResponse))
+	} else if err != nil {
+		return encodeText(protocol.NewError(protocol.ErrCodeInternal, InternalServerErrorResponse))
+	}
+
+	if err := u.tcp.hooks.beforeQuote(info, &quote); err != nil {
+		return encodeText(rejection(err))
+	}
+
+	response := encodeText(protocol.Message{
+		Type:  protocol.TypeQuote,
+		Quote: &protocol.Quote{ID: quote.ID, Text: quote.Text, Author: quote.Author, Tags: quote.Tags},
+	})
+
+	// a client that can't receive the quote keeps its challenge for a padded retry
+	if len(response) > len(request) {
+		return response
+	}
+
+	if err := u.signer.RedeemFor(signed, client); errors.Is(err, challenge.ErrReplayed) {
+		u.tcp.metrics.SolutionRejected(udpListener.label, rejectedReplayed)
+		return encodeText(protocol.NewError(protocol.ErrCodeExpired, ReplayedResponse))
+	} else if err != nil {
+		u.tcp.metrics.SolutionRejected(udpListener.label, rejectedExpired)
+		return encodeText(protocol.NewError(protocol.ErrCodeExpired, ExpiredResponse))
+	}
+
+	u.tcp.reputation.RecordSuccess(client)
+	u.tcp.metrics.SolutionAccepted(udpListener.label)
+	u.tcp.metrics.QuoteServed(udpListener.label)
+	u.tcp.collectMetrics(udpListener.label, startTime)
+
+	return response
+}
+
+func (u *UDPServer) recordFailure(client, reason string) {
+	u.tcp.collectFailedAttempt(udpListener.label, reason)
+	u.tcp.reputation.RecordFailure(client)
+}
+
+// fitResponse keeps a response within the size of the request,
+// replacing it with an error asking for padding or nil if even that doesn't fit
+func fitResponse(response []byte, requestSize int) []byte {
+	if len(response) <= requestSize {
+		return response
+	}
+
+	pad := encodeText(protocol.NewError(protocol.ErrCodeTooLarge, fmt.Sprintf(PadResponse, len(response))))
+	if len(pad) <= requestSize {
+		return pad
+	}
+
+	return nil
+}
+
+// encodeText returns the message as a line of the text mode
+func encodeText(msg protocol.Message) []byte {
+	var buf bytes.Buffer
+	newTextCodec(nil, &buf).Write(msg)
+
+	return buf.Bytes()
+}
+
+// addrHost returns the host part of the address
+func addrHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// pad fills a datagram up to size bytes after its first line
+func pad(line string, size int) []byte {
+	datagram := line + "\n"
+	return []byte(datagram + strings.Repeat(" ", size-len(datagram)))
+}
+
+func udpAddr(host string) net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: 5000}
+}
+
+func TestUDPServer_Handle(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	// longer than a bare solution datagram
+	quote := quotes.Quote{Text: strings.Repeat("Knowing yourself is the beginning of all wisdom. ", 5), Author: "Aristotle"}
+
+	powManager.EXPECT().GenerateChallenge(4).Return("seed:4", nil)
+	powManager.EXPECT().VerifySolution("seed:4", 7).Return(false, nil)
+	powManager.EXPECT().VerifySolution("seed:4", 42).Return(true, nil).Times(3)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{Author: "Aristotle"}).Return(quote, nil).Times(3)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, quoter, powManager)
+	udp := NewUDPServer(0, tcp, signer)
+
+	response := string(udp.handle(pad("QUOTE", 512), udpAddr("192.0.2.1")))
+	signed, ok := strings.CutPrefix(strings.TrimSpace(response), protocol.ChallengePrefix+" ")
+	assert.True(t, ok)
+
+	expectError := func(request []byte, client, code string) {
+		msg, ok := protocol.ParseTextError(string(udp.handle(request, udpAddr(client))))
+		assert.True(t, ok)
+		assert.Equal(t, code, msg.Code)
+	}
+
+	expectError(pad("PING", 512), "192.0.2.1", protocol.ErrCodeUnknownCommand)
+	expectError(pad("QUOTE "+signed, 512), "192.0.2.1", protocol.ErrCodeBadFormat)
+	expectError(pad("QUOTE "+signed+" 4two", 512), "192.0.2.1", protocol.ErrCodeBadFormat)
+	expectError(pad("QUOTE "+signed+" 7", 512), "192.0.2.1", protocol.ErrCodeWrongSolution)
+	// the challenge is bound to the address it was sent to
+	expectError(pad("QUOTE "+signed+" 42", 512), "192.0.2.2", protocol.ErrCodeBadFormat)
+
+	// a request too small for the quote keeps the challenge valid
+	solution := "QUOTE " + signed + " 42 author=Aristotle"
+	assert.Greater(t, len(udp.handle([]byte(solution), udpAddr("192.0.2.1"))), len(solution))
+
+	response = string(udp.handle(pad(solution, 512), udpAddr("192.0.2.1")))
+	assert.Equal(t, quote.String()+"\n", response)
+
+	expectError(pad(solution, 512), "192.0.2.1", protocol.ErrCodeExpired)
+
+	stats := tcp.stats()
+	assert.Equal(t, 1, stats.requestsHandled)
+	assert.Equal(t, 2, stats.failedAttempts)
+}
+
+func TestUDPServer_RejectedChallenges(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	// expired or forged challenges don't count against the client
+	reputation := mocks.NewMockReputationTracker(c)
+	reputation.EXPECT().IsBanned("192.0.2.1").Return(false).AnyTimes()
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, mocks.NewMockQuoter(c), mocks.NewMockProofOfWorkManager(c), WithReputation(reputation))
+	udp := NewUDPServer(0, tcp, signer)
+
+	expectError := func(signed, code string) {
+		msg, ok := protocol.ParseTextError(string(udp.handle(pad("QUOTE "+signed+" 42", 512), udpAddr("192.0.2.1"))))
+		assert.True(t, ok)
+		assert.Equal(t, code, msg.Code)
+	}
+
+	expectError(signer.SignFor("seed:4", "192.0.2.1", -time.Minute), protocol.ErrCodeExpired)
+	expectError("seed:4:1:00", protocol.ErrCodeBadFormat)
+
+	assert.Equal(t, 0, tcp.stats().failedAttempts)
+}
+
+func TestFitResponse(t *testing.T) {
+	response := []byte(strings.Repeat("a", 200) + "\n")
+
+	assert.Equal(t, response, fitResponse(response, 201))
+
+	pad := fitResponse(response, 100)
+	assert.Equal(t, protocol.ErrCodeTooLarge+" "+fmt.Sprintf(PadResponse, 201)+"\n", string(pad))
+
+	assert.Nil(t, fitResponse(response, 10))
+}
+
+func TestUDPServer_NoAmplification(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	powManager.EXPECT().GenerateChallenge(4).Return("0123456789abcdef0123456789abcdef:4", nil).Times(3)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	udp := NewUDPServer(0, NewTCPServer(0, 4, mocks.NewMockQuoter(c), powManager), signer)
+
+	socket, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		err := udp.Serve(socket)
+		assert.NoError(t, err)
+	}()
+	defer udp.Shutdown()
+
+	conn, err := net.Dial("udp", socket.LocalAddr().String())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	buf := make([]byte, 2048)
+	for _, size := range []int{6, 64, 512} {
+		request := pad("QUOTE", size)
+		conn.Write(request)
+
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if size == 6 {
+			// nothing fits into a bare request
+			assert.Error(t, err)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, n, len(request))
+
+		response := string(buf[:n])
+		if size == 64 {
+			msg, ok := protocol.ParseTextError(response)
+			assert.True(t, ok)
+			assert.Equal(t, protocol.ErrCodeTooLarge, msg.Code)
+		} else {
+			assert.True(t, strings.HasPrefix(response, protocol.ChallengePrefix+" "))
+		}
+	}
+}
+
+func TestUDPServer_ShutdownBeforeServe(t *testing.T) {
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	udp := NewUDPServer(0, NewTCPServer(0, 4, nil, nil), signer)
+	udp.Shutdown()
+
+	socket, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	served := make(chan error)
+	go func() {
+		served <- udp.Serve(socket)
+	}()
+
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve doesn't return after Shutdown")
+	}
+
+	_, err = socket.WriteTo([]byte("QUOTE"), socket.LocalAddr())
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// websocketGUID is the magic value of the opening handshake, RFC 6455 section 1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation byte = 0x0
+	wsText         byte = 0x1
+	wsBinary       byte = 0x2
+	wsClose        byte = 0x8
+	wsPing         byte = 0x9
+	wsPong         byte = 0xA
+)
+
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooLarge      = 1009
+
+	// maxControlPayload is the limit of control frames, RFC 6455 section 5.5
+	maxControlPayload = 125
+	// maxFramePayload bounds data frames, the session limits the length of a request on its own
+	maxFramePayload = 64 * 1024
+)
+
+var errWebSocketProtocol = errors.New("websocket protocol violation")
+
+// handleWebSocket upgrades the request and runs the same exchange as a TCP connection on it.
+// Every WebSocket message carries a single line of the text or JSON mode.
+func (h *HTTPServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-Websocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		writeJSON(w, http.StatusBadRequest, protocol.NewError(protocol.ErrCodeBadFormat, "WebSocket upgrade expected"))
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, protocol.NewError(protocol.ErrCodeInternal, InternalServerErrorResponse))
+		return
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		h.tcp.logger.Errorf("failed to hijack connection: %s", err)
+		return
+	}
+
+	// the deadlines of the HTTP server don't apply to the session
+	conn.SetDeadline(time.Time{})
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	// TLS, if any, has been terminated by the HTTP server. The session takes a worker of the pool
+	// like a TCP connection, the goroutine of the request is done with the hijacked connection.
+	h.tcp.dispatch(newWebSocketConn(conn, rw.Reader), webSocketListener)
+}
+
+// webSocketConn presents a server side WebSocket connection as a stream of lines:
+// reads return the payload of every message followed by a newline,
+// every write is sent as a single text message without the trailing newline.
+type webSocketConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	// the state of the data frame being read
+	remaining int64
+	mask      [4]byte
+	maskPos   int
+	final     bool
+	inMessage bool
+	lastByte  byte
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader) *webSocketConn {
+	return &webSocketConn{Conn: conn, reader: reader}
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for c.remaining == 0 {
+		if c.inMessage && c.final {
+			c.inMessage = false
+			if c.lastByte != '\n' {
+				p[0] = '\n'
+				return 1, nil
+			}
+			continue
+		}
+
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.reader.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.maskPos]
+		c.maskPos = (c.maskPos + 1) % 4
+	}
+	c.remaining -= int64(n)
+	if n > 0 {
+		c.lastByte = p[n-1]
+	}
+
+	return n, err
+}
+
+// nextFrame reads the header of the next data frame, handling control frames on the way
+func (c *webSocketConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	final := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+
+	// clients must mask their frames
+	if !masked {
+		return c.fail(wsCloseProtocolError, errors.Wrap(errWebSocketProtocol, "unmasked frame"))
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return err
+	}
+
+	if opcode >= wsClose {
+		return c.handleControl(opcode, final, length, mask)
+	}
+
+	switch {
+	case opcode == wsContinuation && !c.inMessage:
+		return c.fail(wsCloseProtocolError, errors.Wrap(errWebSocketProtocol, "unexpected continuation frame"))
+	case (opcode == wsText || opcode == wsBinary) && c.inMessage:
+		return c.fail(wsCloseProtocolError, errors.Wrap(errWebSocketProtocol, "unfinished message"))
+	case opcode != wsContinuation && opcode != wsText && opcode != wsBinary:
+		return c.fail(wsCloseProtocolError, errors.Wrapf(errWebSocketProtocol, "unknown opcode %d", opcode))
+	}
+
+	if length > maxFramePayload {
+		c.fail(wsCloseTooLarge, nil)
+		return errors.Wrapf(protocol.ErrTooLarge, "frame of %d bytes", length)
+	}
+
+	if opcode != wsContinuation {
+		c.inMessage, c.lastByte = true, 0
+	}
+	c.final, c.remaining, c.mask, c.maskPos = final, length, mask, 0
+
+	return nil
+}
+
+func (c *webSocketConn) handleControl(opcode byte, final bool, length int64, mask [4]byte) error {
+	if !final || length > maxControlPayload {
+		return c.fail(wsCloseProtocolError, errors.Wrap(errWebSocketProtocol, "invalid control frame"))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	switch opcode {
+	case wsPing:
+		return c.writeFrame(wsPong, payload)
+	case wsClose:
+		c.fail(wsCloseNormal, nil)
+		return io.EOF
+	}
+
+	return nil
+}
+
+// fail sends a close frame with the code and returns err
+func (c *webSocketConn) fail(code int, err error) error {
+	c.closeOnce.Do(func() {
+		payload := make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		c.writeFrame(wsClose, payload)
+	})
+
+	return err
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsText, []byte(strings.TrimSuffix(string(p), "\n"))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	_, err := c.Conn.Write(append(frame, payload...))
+	return err
+}
+
+func (c *webSocketConn) Close() error {
+	// the close frame is a courtesy, a client that doesn't read doesn't get to delay closing
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.fail(wsCloseNormal, nil)
+	return c.Conn.Close()
+}
+
+// headerContains reports whether a comma separated header has the token, case-insensitively
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhashkevych/quotes-server/internal/challenge"
+	"github.com/zhashkevych/quotes-server/internal/quotes"
+	"github.com/zhashkevych/quotes-server/internal/server/mocks"
+	"github.com/zhashkevych/quotes-server/pkg/protocol"
+)
+
+// wsClient is a bare WebSocket client for the tests
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, addr string) *wsClient {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", addr, key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(accept[:]), resp.Header.Get("Sec-Websocket-Accept"))
+
+	return &wsClient{conn: conn, reader: reader}
+}
+
+func (c *wsClient) send(opcode byte, final bool, payload string) {
+	first := opcode
+	if final {
+		first |= 0x80
+	}
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	c.conn.Write(frame)
+}
+
+func (c *wsClient) receive() (byte, string, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, "", err
+	}
+
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.reader, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+
+	payload := make([]byte, length)
+	_, err := io.ReadFull(c.reader, payload)
+
+	return header[0] & 0x0F, string(payload), err
+}
+
+func TestHTTPServer_WebSocket(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	quoter := mocks.NewMockQuoter(c)
+	powManager := mocks.NewMockProofOfWorkManager(c)
+
+	quote := quotes.Quote{Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle"}
+
+	gomock.InOrder(
+		powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil),
+		powManager.EXPECT().VerifySolution("greeting", 1).Return(true, nil),
+		powManager.EXPECT().GenerateChallenge(4).Return("second", nil),
+		powManager.EXPECT().VerifySolution("second", 2).Return(true, nil),
+	)
+	quoter.EXPECT().GetRandomQuote(quotes.Filter{}).Return(quote, nil).Times(2)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, quoter, powManager, WithKeepAlive(GatePerCommand, 1))
+	httpServer := httptest.NewServer(NewHTTPServer(0, tcp, signer).Handler())
+	defer httpServer.Close()
+
+	client := dialWebSocket(t, strings.TrimPrefix(httpServer.URL, "http://"))
+	defer client.conn.Close()
+
+	exchange := func(request string, expected ...string) {
+		if request != "" {
+			client.send(wsText, true, request)
+		}
+		for _, line := range expected {
+			opcode, payload, err := client.receive()
+			assert.NoError(t, err)
+			assert.Equal(t, wsText, opcode)
+			assert.Equal(t, line, payload)
+		}
+	}
+
+	exchange("", "greeting")
+
+	// a fragmented command
+	client.send(wsText, false, "QU")
+	client.send(wsContinuation, true, "OTE")
+	exchange("", "CHALLENGE greeting")
+	exchange("1", quote.String())
+
+	client.send(wsPing, true, "hello")
+	opcode, payload, err := client.receive()
+	assert.NoError(t, err)
+	assert.Equal(t, wsPong, opcode)
+	assert.Equal(t, "hello", payload)
+
+	exchange("QUOTE", "CHALLENGE second")
+	exchange("2\n", quote.String())
+	exchange("QUIT", ByeResponse)
+
+	opcode, _, err = client.receive()
+	assert.NoError(t, err)
+	assert.Equal(t, wsClose, opcode)
+}
+
+func TestHTTPServer_WebSocketUnmasked(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, mocks.NewMockQuoter(c), powManager)
+	httpServer := httptest.NewServer(NewHTTPServer(0, tcp, signer).Handler())
+	defer httpServer.Close()
+
+	client := dialWebSocket(t, strings.TrimPrefix(httpServer.URL, "http://"))
+	defer client.conn.Close()
+
+	_, greeting, err := client.receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "greeting", greeting)
+
+	client.conn.Write([]byte{0x81, 0x02, '4', '2'})
+
+	opcode, payload, err := client.receive()
+	assert.NoError(t, err)
+	assert.Equal(t, wsClose, opcode)
+	assert.Equal(t, uint16(wsCloseProtocolError), binary.BigEndian.Uint16([]byte(payload)))
+}
+
+func TestHTTPServer_ExamplePage(t *testing.T) {
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	handler := NewHTTPServer(0, NewTCPServer(0, 4, nil, nil), signer).Handler()
+
+	for _, path := range []string{"/", "/solver.js"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+func TestHTTPServer_WebSocketPool(t *testing.T) {
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	powManager := mocks.NewMockProofOfWorkManager(c)
+	powManager.EXPECT().GenerateChallenge(4).Return("greeting", nil)
+
+	signer, err := challenge.NewSigner(nil, time.Minute)
+	assert.NoError(t, err)
+
+	tcp := NewTCPServer(0, 4, mocks.NewMockQuoter(c), powManager, WithWorkerPool(PoolPolicy{Workers: 1}))
+	go func() {
+		err := tcp.ListenAndServe()
+		assert.NoError(t, err)
+	}()
+	defer tcp.CloseListeners()
+
+	httpServer := httptest.NewServer(NewHTTPServer(0, tcp, signer).Handler())
+	defer httpServer.Close()
+
+	// the only worker is busy with a TCP client
+	busy, err := net.Dial("tcp", tcp.getAddr())
+	assert.NoError(t, err)
+
+	defer busy.Close()
+
+	_, err = bufio.NewReader(busy).ReadString('\n')
+	assert.NoError(t, err)
+
+	// a session takes a worker like a TCP connection
+	client := dialWebSocket(t, strings.TrimPrefix(httpServer.URL, "http://"))
+	defer client.conn.Close()
+
+	opcode, payload, err := client.receive()
+	assert.NoError(t, err)
+	assert.Equal(t, wsText, opcode)
+	assert.Equal(t, protocol.ErrCodeBusy+" "+BusyResponse, payload)
+	assert.Equal(t, 1, tcp.stats().droppedConnections)
+}
@@ -0,0 +1,50 @@
+// Hashcash solver matching pkg/hashcash: a challenge is "seed:difficulty[:...]",
+// the solution is the first nonce for which the hex encoded SHA-256 of "seed:nonce"
+// starts with difficulty zeros. Signed challenges of the HTTP frontend carry
+// an expiry and a signature after the difficulty, those fields are not hashed.
+
+const encoder = new TextEncoder();
+
+function toHex(buffer) {
+  return Array.from(new Uint8Array(buffer), (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+export function parseChallenge(challenge) {
+  const [seed, difficulty] = challenge.split(":");
+  const parsed = parseInt(difficulty, 10);
+  if (!seed || Number.isNaN(parsed)) {
+    throw new Error(`invalid challenge format: ${challenge}`);
+  }
+  return { seed, difficulty: parsed };
+}
+
+export async function verify(challenge, nonce) {
+  const { seed, difficulty } = parseChallenge(challenge);
+  const hash = await crypto.subtle.digest("SHA-256", encoder.encode(`${seed}:${nonce}`));
+  return toHex(hash).startsWith("0".repeat(difficulty));
+}
+
+// solve returns the first nonce solving the challenge.
+// onProgress, if set, is called with the number of hashes tried every batch.
+export async function solve(challenge, onProgress) {
+  const { seed, difficulty } = parseChallenge(challenge);
+  const prefix = "0".repeat(difficulty);
+  const batch = 1024;
+
+  for (let nonce = 0; ; nonce += batch) {
+    const hashes = await Promise.all(
+      Array.from({ length: batch }, (_, i) =>
+        crypto.subtle.digest("SHA-256", encoder.encode(`${seed}:${nonce + i}`)),
+      ),
+    );
+
+    const found = hashes.findIndex((hash) => toHex(hash).startsWith(prefix));
+    if (found >= 0) {
+      return nonce + found;
+    }
+
+    if (onProgress) {
+      onProgress(nonce + batch);
+    }
+  }
+}
@@ -0,0 +1,20 @@
+// Package web holds the example page of the WebSocket gateway
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var static embed.FS
+
+// Files returns the example page and the JavaScript solver
+func Files() fs.FS {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	return files
+}
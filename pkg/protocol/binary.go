@@ -0,0 +1,216 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// frameHeaderSize is the size of the frame type byte followed by the big-endian uint32 payload length
+const frameHeaderSize = 5
+
+// Frame type bytes
+const (
+	frameChallenge  byte = 0x01
+	frameSolution   byte = 0x02
+	frameCommand    byte = 0x03
+	frameQuote      byte = 0x04
+	frameCategories byte = 0x05
+	frameStats      byte = 0x06
+	framePong       byte = 0x07
+	frameBye        byte = 0x08
+	frameError      byte = 0x09
+)
+
+type frameType struct {
+	messageType    MessageType
+	maxPayloadSize int
+}
+
+var frameTypes = map[byte]frameType{
+	frameChallenge:  {TypeChallenge, 256},
+	frameSolution:   {TypeSolution, 8},
+	frameCommand:    {TypeCommand, 1024},
+	frameQuote:      {TypeQuote, 64 * 1024},
+	frameCategories: {TypeCategories, 64 * 1024},
+	frameStats:      {TypeStats, 1024},
+	framePong:       {TypePong, 0},
+	frameBye:        {TypeBye, 0},
+	frameError:      {TypeError, 1024},
+}
+
+// maxSkippedPayloadSize is the largest payload of an unknown frame type that is skipped instead of failing the stream
+const maxSkippedPayloadSize = 1024
+
+// BinaryCodec encodes every message as a length-prefixed frame:
+//
+//	| type (1 byte) | payload length (4 bytes, big-endian) | payload |
+//
+// The payload layout depends on the frame type:
+//   - challenge: difficulty (1 byte) followed by the challenge string
+//   - solution: nonce as a big-endian uint64
+//   - command: "<command> <args>"
+//   - quote, categories, stats: JSON encoded Quote, []string and Stats
+//   - pong, bye: empty
+//   - error: code length (1 byte), code, error text
+type BinaryCodec struct {
+	reader io.Reader
+	writer io.Writer
+}
+
+func NewBinaryCodec(reader *bufio.Reader, writer io.Writer) *BinaryCodec {
+	return &BinaryCodec{reader: reader, writer: writer}
+}
+
+func (c *BinaryCodec) Read() (Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return Message{}, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+
+	ft, ok := frameTypes[header[0]]
+	if !ok {
+		if size > maxSkippedPayloadSize {
+			return Message{}, errors.Wrapf(ErrTooLarge, "unknown frame type 0x%02x of %d bytes", header[0], size)
+		}
+		if _, err := io.CopyN(io.Discard, c.reader, int64(size)); err != nil {
+			return Message{}, err
+		}
+		return Message{}, errors.Wrapf(ErrMalformed, "unknown frame type 0x%02x", header[0])
+	}
+
+	if size > uint32(ft.maxPayloadSize) {
+		return Message{}, errors.Wrapf(ErrTooLarge, "%s frame of %d bytes exceeds the limit of %d bytes",
+			ft.messageType, size, ft.maxPayloadSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Message{}, err
+	}
+
+	msg, err := decodePayload(ft.messageType, payload)
+	if err != nil {
+		return Message{}, errors.Wrapf(ErrMalformed, "invalid %s frame: %s", ft.messageType, err)
+	}
+
+	return msg, nil
+}
+
+func (c *BinaryCodec) Write(msg Message) error {
+	frame, err := EncodeFrame(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.writer.Write(frame)
+	return err
+}
+
+// EncodeFrame encodes the message as a single binary frame
+func EncodeFrame(msg Message) ([]byte, error) {
+	typ, payload, err := encodePayload(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) > frameTypes[typ].maxPayloadSize {
+		return nil, errors.Wrapf(ErrTooLarge, "%s frame of %d bytes exceeds the limit of %d bytes",
+			msg.Type, len(payload), frameTypes[typ].maxPayloadSize)
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+
+	return append(frame, payload...), nil
+}
+
+func encodePayload(msg Message) (byte, []byte, error) {
+	switch msg.Type {
+	case TypeChallenge:
+		if msg.Difficulty < 0 || msg.Difficulty > 255 {
+			return 0, nil, fmt.Errorf("difficulty %d doesn't fit a byte", msg.Difficulty)
+		}
+		return frameChallenge, append([]byte{byte(msg.Difficulty)}, msg.Challenge...), nil
+	case TypeSolution:
+		return frameSolution, binary.BigEndian.AppendUint64(nil, uint64(msg.Nonce)), nil
+	case TypeCommand:
+		command := msg.Command
+		if msg.Args != "" {
+			command += " " + msg.Args
+		}
+		return frameCommand, []byte(command), nil
+	case TypeQuote:
+		data, err := json.Marshal(msg.Quote)
+		return frameQuote, data, err
+	case TypeCategories:
+		data, err := json.Marshal(msg.Categories)
+		return frameCategories, data, err
+	case TypeStats:
+		data, err := json.Marshal(msg.Stats)
+		return frameStats, data, err
+	case TypePong:
+		return framePong, nil, nil
+	case TypeBye:
+		return frameBye, nil, nil
+	case TypeError:
+		if len(msg.Code) > 255 {
+			return 0, nil, fmt.Errorf("error code %q is too long", msg.Code)
+		}
+		payload := append([]byte{byte(len(msg.Code))}, msg.Code...)
+		return frameError, append(payload, msg.Error...), nil
+	default:
+		return 0, nil, fmt.Errorf("unknown message type %q", msg.Type)
+	}
+}
+
+func decodePayload(typ MessageType, payload []byte) (Message, error) {
+	msg := Message{Type: typ}
+
+	switch typ {
+	case TypeChallenge:
+		if len(payload) < 1 {
+			return msg, errors.New("difficulty is missing")
+		}
+		msg.Difficulty, msg.Challenge = int(payload[0]), string(payload[1:])
+	case TypeSolution:
+		if len(payload) != 8 {
+			return msg, fmt.Errorf("nonce must be 8 bytes, got %d", len(payload))
+		}
+		msg.Nonce = int(binary.BigEndian.Uint64(payload))
+	case TypeCommand:
+		command, args, _ := strings.Cut(string(payload), " ")
+		msg.Command, msg.Args = command, args
+	case TypeQuote:
+		if err := json.Unmarshal(payload, &msg.Quote); err != nil || msg.Quote == nil {
+			return msg, errors.New("quote must be a JSON object")
+		}
+	case TypeCategories:
+		if err := json.Unmarshal(payload, &msg.Categories); err != nil {
+			return msg, err
+		}
+	case TypeStats:
+		if err := json.Unmarshal(payload, &msg.Stats); err != nil || msg.Stats == nil {
+			return msg, errors.New("stats must be a JSON object")
+		}
+	case TypeError:
+		if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+			return msg, errors.New("error code is truncated")
+		}
+		n := 1 + int(payload[0])
+		msg.Code, msg.Error = string(payload[1:n]), string(payload[n:])
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryCodec_RoundTrip(t *testing.T) {
+	messages := []Message{
+		{Type: TypeChallenge, Challenge: "seed:4", Difficulty: 4},
+		{Type: TypeSolution, Nonce: 42},
+		{Type: TypeCommand, Command: CommandQuote, Args: "2 author=Aristotle"},
+		{Type: TypeCommand, Command: CommandPing},
+		{Type: TypeQuote, Quote: &Quote{ID: 1, Text: "First line\nsecond line", Author: "Anonymous", Tags: []string{"poetry"}}},
+		{Type: TypeCategories, Categories: []string{"life", "wisdom"}},
+		{Type: TypeStats, Stats: &Stats{RequestsHandled: 3, AverageResponseTimeMs: 1.5, Credits: 2}},
+		{Type: TypePong},
+		{Type: TypeBye},
+		NewError(ErrCodeWrongSolution, "Incorrect solution. Try again."),
+	}
+
+	var buf bytes.Buffer
+	codec := NewBinaryCodec(bufio.NewReader(&buf), &buf)
+
+	for _, msg := range messages {
+		assert.NoError(t, codec.Write(msg))
+	}
+
+	for _, expected := range messages {
+		msg, err := codec.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+
+	_, err := codec.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestBinaryCodec_SizeLimits(t *testing.T) {
+	// writing an oversized frame fails before anything is sent
+	var buf bytes.Buffer
+	codec := NewBinaryCodec(bufio.NewReader(&buf), &buf)
+
+	err := codec.Write(Message{Type: TypeCommand, Command: CommandQuote, Args: strings.Repeat("a", 1024)})
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.Zero(t, buf.Len())
+
+	// reading checks the announced length before reading the payload
+	header := []byte{frameSolution, 0, 0, 0, 9}
+	codec = NewBinaryCodec(bufio.NewReader(bytes.NewReader(header)), io.Discard)
+
+	_, err = codec.Read()
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestBinaryCodec_ReadMalformed(t *testing.T) {
+	stream := []byte{
+		0x7f, 0, 0, 0, 2, 'h', 'i', // unknown frame type, skipped
+		frameSolution, 0, 0, 0, 1, 42, // nonce of a wrong size
+		frameQuote, 0, 0, 0, 4, 'n', 'u', 'l', 'l', // quote without a body
+		framePong, 0, 0, 0, 0,
+	}
+	codec := NewBinaryCodec(bufio.NewReader(bytes.NewReader(stream)), io.Discard)
+
+	for i := 0; i < 3; i++ {
+		_, err := codec.Read()
+		assert.ErrorIs(t, err, ErrMalformed)
+	}
+
+	msg, err := codec.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, Message{Type: TypePong}, msg)
+}
+
+func TestBinaryCodec_ReadTruncated(t *testing.T) {
+	codec := NewBinaryCodec(bufio.NewReader(bytes.NewReader([]byte{frameCommand, 0, 0, 0, 4, 'P'})), io.Discard)
+
+	_, err := codec.Read()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func FuzzBinaryCodec_Read(f *testing.F) {
+	for _, msg := range []Message{
+		{Type: TypeChallenge, Challenge: "seed:4", Difficulty: 4},
+		{Type: TypeSolution, Nonce: 42},
+		{Type: TypeCommand, Command: CommandQuote, Args: "author=Aristotle"},
+		{Type: TypeQuote, Quote: &Quote{ID: 1, Text: "text", Author: "author"}},
+		{Type: TypeStats, Stats: &Stats{RequestsHandled: 1}},
+		NewError(ErrCodeBadFormat, "bad"),
+	} {
+		frame, err := EncodeFrame(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(frame)
+	}
+	f.Add([]byte{frameQuote, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{frameError, 0, 0, 0, 1, 200})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		codec := NewBinaryCodec(bufio.NewReader(bytes.NewReader(data)), io.Discard)
+
+		for {
+			msg, err := codec.Read()
+			switch {
+			case errors.Is(err, ErrMalformed):
+				continue
+			case err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, ErrTooLarge):
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// every decoded message must survive a round trip within the limits of its type
+			frame, err := EncodeFrame(msg)
+			if err != nil {
+				t.Fatalf("failed to encode decoded message %+v: %v", msg, err)
+			}
+
+			size := binary.BigEndian.Uint32(frame[1:frameHeaderSize])
+			if int(size) > frameTypes[frame[0]].maxPayloadSize {
+				t.Fatalf("encoded %s frame of %d bytes exceeds its limit", msg.Type, size)
+			}
+
+			decoded, err := NewBinaryCodec(bufio.NewReader(bytes.NewReader(frame)), io.Discard).Read()
+			if err != nil {
+				t.Fatalf("failed to decode re-encoded message %+v: %v", msg, err)
+			}
+			if !assert.ObjectsAreEqual(msg, decoded) {
+				t.Fatalf("round trip changed the message: %+v != %+v", msg, decoded)
+			}
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import "strings"
+
+// Error codes carried by error messages in every protocol mode.
+// The codes are stable, clients may rely on them instead of the error text.
+const (
+	// ErrCodeBadFormat - the message couldn't be parsed, e.g. a nonce that is not a number
+	ErrCodeBadFormat = "ERR_BAD_FORMAT"
+	// ErrCodeUnknownCommand - the command is not supported
+	ErrCodeUnknownCommand = "ERR_UNKNOWN_COMMAND"
+	// ErrCodeWrongSolution - the nonce doesn't solve the challenge
+	ErrCodeWrongSolution = "ERR_WRONG_SOLUTION"
+	// ErrCodeExpired - the challenge expired before a solution arrived
+	ErrCodeExpired = "ERR_EXPIRED"
+	// ErrCodeTimeout - the client didn't send a request in time
+	ErrCodeTimeout = "ERR_TIMEOUT"
+	// ErrCodeTooLarge - the message exceeds its size limit
+	ErrCodeTooLarge = "ERR_TOO_LARGE"
+	// ErrCodeRateLimited - the client sends too many requests or has been banned
+	ErrCodeRateLimited = "ERR_RATE_LIMITED"
+	// ErrCodeNotFound - no quote matches the request
+	ErrCodeNotFound = "ERR_NOT_FOUND"
+	// ErrCodeInternal - the server failed to handle the request
+	ErrCodeInternal = "ERR_INTERNAL"
+	// ErrCodeShuttingDown - the server is going away, the client may retry later
+	ErrCodeShuttingDown = "ERR_SHUTTING_DOWN"
+	// ErrCodeBusy - the server has no room for another connection, the client may retry later
+	ErrCodeBusy = "ERR_BUSY"
+)
+
+var errorCodes = map[string]struct{}{
+	ErrCodeBadFormat:      {},
+	ErrCodeUnknownCommand: {},
+	ErrCodeWrongSolution:  {},
+	ErrCodeExpired:        {},
+	ErrCodeTimeout:        {},
+	ErrCodeTooLarge:       {},
+	ErrCodeRateLimited:    {},
+	ErrCodeNotFound:       {},
+	ErrCodeInternal:       {},
+	ErrCodeShuttingDown:   {},
+	ErrCodeBusy:           {},
+}
+
+// IsErrorCode reports whether the code belongs to the error catalog
+func IsErrorCode(code string) bool {
+	_, ok := errorCodes[code]
+	return ok
+}
+
+// NewError builds an error message
+func NewError(code, text string) Message {
+	return Message{Type: TypeError, Code: code, Error: text}
+}
+
+// ParseTextError parses an error line of the text mode: "<code> <text>"
+func ParseTextError(line string) (Message, bool) {
+	code, text, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if !IsErrorCode(code) {
+		return Message{}, false
+	}
+
+	return NewError(code, text), true
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTextError(t *testing.T) {
+	msg, ok := ParseTextError("ERR_WRONG_SOLUTION Incorrect solution. Try again.\n")
+	assert.True(t, ok)
+	assert.Equal(t, NewError(ErrCodeWrongSolution, "Incorrect solution. Try again."), msg)
+
+	msg, ok = ParseTextError(ErrCodeExpired)
+	assert.True(t, ok)
+	assert.Equal(t, NewError(ErrCodeExpired, ""), msg)
+
+	_, ok = ParseTextError("ERR_SOMETHING went wrong")
+	assert.False(t, ok)
+
+	_, ok = ParseTextError("Knowing yourself is the beginning of all wisdom. - Aristotle")
+	assert.False(t, ok)
+}
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// JSONCodec encodes every message as a single JSON object per line.
+// The length of a line is limited by the size of the reader's buffer.
+type JSONCodec struct {
+	reader *bufio.Reader
+	writer io.Writer
+}
+
+func NewJSONCodec(reader *bufio.Reader, writer io.Writer) *JSONCodec {
+	return &JSONCodec{reader: reader, writer: writer}
+}
+
+func (c *JSONCodec) Read() (Message, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return Message{}, errors.Wrapf(ErrTooLarge, "line exceeds %d bytes", c.reader.Size())
+		}
+		return Message{}, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return Message{}, errors.Wrap(ErrMalformed, err.Error())
+	}
+
+	if msg.Type == "" {
+		return Message{}, errors.Wrap(ErrMalformed, "message type is missing")
+	}
+
+	return msg, nil
+}
+
+func (c *JSONCodec) Write(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.writer.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONCodec_RoundTrip(t *testing.T) {
+	messages := []Message{
+		{Type: TypeChallenge, Challenge: "seed:4", Difficulty: 4},
+		{Type: TypeSolution, Nonce: 42},
+		{Type: TypeCommand, Command: CommandQuote, Args: "2 author=Aristotle"},
+		{Type: TypeQuote, Quote: &Quote{ID: 1, Text: "Knowing yourself is the beginning of all wisdom.", Author: "Aristotle", Tags: []string{"wisdom"}}},
+		NewError(ErrCodeWrongSolution, "Incorrect solution. Try again."),
+	}
+
+	var buf bytes.Buffer
+	codec := NewJSONCodec(bufio.NewReader(&buf), &buf)
+
+	for _, msg := range messages {
+		assert.NoError(t, codec.Write(msg))
+	}
+
+	assert.Equal(t, len(messages), strings.Count(buf.String(), "\n"))
+
+	for _, expected := range messages {
+		msg, err := codec.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+
+	_, err := codec.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestJSONCodec_ReadMalformed(t *testing.T) {
+	for _, input := range []string{"not json\n", "{\"nonce\":42}\n"} {
+		codec := NewJSONCodec(bufio.NewReader(strings.NewReader(input)), io.Discard)
+
+		_, err := codec.Read()
+		assert.ErrorIs(t, err, ErrMalformed)
+	}
+}
+
+func TestJSONCodec_ReadTooLarge(t *testing.T) {
+	input := `{"type":"command","command":"QUOTE","args":"` + strings.Repeat("a", 64) + `"}` + "\n"
+	codec := NewJSONCodec(bufio.NewReaderSize(strings.NewReader(input), 32), io.Discard)
+
+	_, err := codec.Read()
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -0,0 +1,98 @@
+// Package protocol describes the messages exchanged between the quotes server and its clients.
+//
+// Every connection starts in the line based text mode: the server sends a challenge line
+// and the client answers with a nonce, a command or "PROTO <mode>" to switch the rest
+// of the connection to another wire format.
+package protocol
+
+import "errors"
+
+var (
+	// ErrMalformed is returned by codecs when a message can't be decoded
+	ErrMalformed = errors.New("malformed message")
+	// ErrTooLarge is returned by codecs when a message exceeds its size limit.
+	// The stream can't be resynchronized after it.
+	ErrTooLarge = errors.New("message too large")
+)
+
+// NegotiateCommand switches the connection to another protocol mode: "PROTO <mode>"
+const NegotiateCommand = "PROTO"
+
+// Protocol modes
+const (
+	ModeText   = "text"
+	ModeJSON   = "json"
+	ModeBinary = "binary"
+)
+
+// Commands accepted in a keep-alive session
+const (
+	CommandQuote      = "QUOTE"
+	CommandCategories = "CATEGORIES"
+	CommandPing       = "PING"
+	CommandStats      = "STATS"
+	CommandQuit       = "QUIT"
+)
+
+// ChallengePrefix starts the text line that asks a session client to solve a challenge
+const ChallengePrefix = "CHALLENGE"
+
+type MessageType string
+
+const (
+	TypeChallenge  MessageType = "challenge"
+	TypeSolution   MessageType = "solution"
+	TypeCommand    MessageType = "command"
+	TypeQuote      MessageType = "quote"
+	TypeCategories MessageType = "categories"
+	TypeStats      MessageType = "stats"
+	TypePong       MessageType = "pong"
+	TypeBye        MessageType = "bye"
+	TypeError      MessageType = "error"
+)
+
+// Message is a single protocol message. Only the fields of its type are set.
+type Message struct {
+	Type MessageType `json:"type"`
+
+	// challenge
+	Challenge  string `json:"challenge,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+
+	// solution
+	Nonce int `json:"nonce,omitempty"`
+
+	// command
+	Command string `json:"command,omitempty"`
+	Args    string `json:"args,omitempty"`
+
+	// replies
+	Quote      *Quote   `json:"quote,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Stats      *Stats   `json:"stats,omitempty"`
+
+	// error
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type Quote struct {
+	ID     int      `json:"id"`
+	Text   string   `json:"text"`
+	Author string   `json:"author,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type Stats struct {
+	RequestsHandled       int     `json:"requests_handled"`
+	AverageResponseTimeMs float64 `json:"avg_response_time_ms"`
+	FailedAttempts        int     `json:"failed_attempts"`
+	Timeouts              int     `json:"timeouts"`
+	Credits               int     `json:"credits"`
+}
+
+// Codec reads and writes protocol messages in a particular wire format
+type Codec interface {
+	Read() (Message, error)
+	Write(Message) error
+}